@@ -1,6 +1,7 @@
 package superscribe
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/carpenterscode/superscribe/receipt"
@@ -11,7 +12,7 @@ type Notification struct {
 	NotificationType NoteType `json:"notification_type"`
 	Password         string   `json:"password"`
 
-	CancellationDate   *receipt.Millistamp `json:"cancellation_date_ms,string,omitempty"`
+	CancellationDate   *receipt.Millistamp `json:"cancellation_date_ms,omitempty"`
 	WebOrderLineItemID string              `json:"web_order_line_item_id"`
 
 	LatestReceipt            string       `json:"latest_receipt,omitempty"`
@@ -20,7 +21,7 @@ type Notification struct {
 	LatestExpiredReceiptInfo *receiptInfo `json:"latest_expired_receipt_info,omitempty"`
 
 	AutoRenewStatus          bool               `json:"auto_renew_status,string"`
-	AutoRenewStatusChangedAt receipt.Millistamp `json:"auto_renew_status_change_date_ms,string,omitempty"`
+	AutoRenewStatusChangedAt receipt.Millistamp `json:"auto_renew_status_change_date_ms,omitempty"`
 	AutoRenewAdamID          string             `json:"auto_renew_adam_id"`
 	AutoRenewProductID       string             `json:"auto_renew_product_id"`
 	ExpirationIntent         string             `json:"expiration_intent"`
@@ -62,6 +63,17 @@ func (n notification) CancelledAt() time.Time {
 	return time.Time{}
 }
 
+func (n notification) CancellationReason() (receipt.CancellationReason, bool) {
+	reason := n.body.LatestReceiptInfo.CancellationReason
+	if n.body.LatestExpiredReceiptInfo != nil {
+		reason = n.body.LatestExpiredReceiptInfo.CancellationReason
+	}
+	if reason == nil {
+		return 0, false
+	}
+	return receipt.CancellationReason(*reason), true
+}
+
 func (n notification) Environment() Env {
 	return n.body.Env
 }
@@ -73,6 +85,17 @@ func (n notification) ExpiresAt() time.Time {
 	return n.body.LatestReceiptInfo.ExpiresDate.Time()
 }
 
+func (n notification) IsInIntroOfferPeriod() bool {
+	if n.body.LatestExpiredReceiptInfo != nil {
+		return n.body.LatestExpiredReceiptInfo.IsInIntroOfferPeriod
+	}
+	return n.body.LatestReceiptInfo.IsInIntroOfferPeriod
+}
+
+func (n notification) IsInitialPurchase() bool {
+	return n.TransactionID() != "" && n.TransactionID() == n.OriginalTransactionID()
+}
+
 func (n notification) IsTrialPeriod() bool {
 	if n.body.LatestExpiredReceiptInfo != nil {
 		return n.body.LatestExpiredReceiptInfo.IsTrialPeriod
@@ -80,6 +103,13 @@ func (n notification) IsTrialPeriod() bool {
 	return n.body.LatestReceiptInfo.IsTrialPeriod
 }
 
+func (n notification) IsUpgraded() bool {
+	if n.body.LatestExpiredReceiptInfo != nil {
+		return n.body.LatestExpiredReceiptInfo.IsUpgraded
+	}
+	return n.body.LatestReceiptInfo.IsUpgraded
+}
+
 func (n notification) OriginalTransactionID() string {
 	if n.body.LatestExpiredReceiptInfo != nil {
 		return n.body.LatestExpiredReceiptInfo.OriginalTransactionID
@@ -108,6 +138,30 @@ func (n notification) ProductID() string {
 	return n.body.LatestReceiptInfo.ProductID
 }
 
+// ProductType is always auto-renewable because the App Store only notifies about subscriptions
+func (n notification) ProductType() receipt.ProductType {
+	return receipt.ProductTypeAutoRenewable
+}
+
+func (n notification) PromotionalOfferID() string {
+	if n.body.LatestExpiredReceiptInfo != nil {
+		return n.body.LatestExpiredReceiptInfo.PromotionalOfferID
+	}
+	return n.body.LatestReceiptInfo.PromotionalOfferID
+}
+
+func (n notification) Quantity() int {
+	info := n.body.LatestReceiptInfo
+	if n.body.LatestExpiredReceiptInfo != nil {
+		info = *n.body.LatestExpiredReceiptInfo
+	}
+	quantity, err := strconv.Atoi(info.Quantity)
+	if err != nil || quantity < 1 {
+		return 1
+	}
+	return quantity
+}
+
 func (n notification) RefundedAt() time.Time {
 	if n.body.LatestExpiredReceiptInfo != nil {
 		return (*(n.body.CancellationDate)).Time()
@@ -126,6 +180,30 @@ func (n notification) Status() int {
 	return receipt.StatusValid // TODO: Update to use unified receipt in Fall 2019
 }
 
+func (n notification) SubscriptionGroupID() string {
+	if n.body.LatestExpiredReceiptInfo != nil {
+		return n.body.LatestExpiredReceiptInfo.SubscriptionGroupID
+	}
+	return n.body.LatestReceiptInfo.SubscriptionGroupID
+}
+
+func (n notification) TransactionID() string {
+	if n.body.LatestExpiredReceiptInfo != nil {
+		return n.body.LatestExpiredReceiptInfo.TransactionID
+	}
+	return n.body.LatestReceiptInfo.TransactionID
+}
+
+func (n notification) WebOrderLineItemID() string {
+	if n.body.WebOrderLineItemID != "" {
+		return n.body.WebOrderLineItemID
+	}
+	if n.body.LatestExpiredReceiptInfo != nil {
+		return n.body.LatestExpiredReceiptInfo.WebOrderLineItemID
+	}
+	return n.body.LatestReceiptInfo.WebOrderLineItemID
+}
+
 func (n notification) Type() NoteType {
 	return n.body.NotificationType
 }
@@ -135,9 +213,15 @@ type receiptInfo struct {
 	ProductID             string              `json:"product_id"`
 	TransactionID         string              `json:"transaction_id"`
 	OriginalTransactionID string              `json:"original_transaction_id"`
-	PurchaseDate          receipt.Millistamp  `json:"purchase_date_ms,string"`
-	OriginalPurchaseDate  receipt.Millistamp  `json:"original_purchase_date_ms,string"`
-	CancellationDate      *receipt.Millistamp `json:"cancellation_date_ms,string,omitempty"`
+	PurchaseDate          receipt.Millistamp  `json:"purchase_date_ms"`
+	OriginalPurchaseDate  receipt.Millistamp  `json:"original_purchase_date_ms"`
+	CancellationDate      *receipt.Millistamp `json:"cancellation_date_ms,omitempty"`
+	CancellationReason    *int                `json:"cancellation_reason,string,omitempty"`
 	IsTrialPeriod         bool                `json:"is_trial_period,string"`
-	ExpiresDate           receipt.Millistamp  `json:"expires_date,string"`
+	IsInIntroOfferPeriod  bool                `json:"is_in_intro_offer_period,string"`
+	IsUpgraded            bool                `json:"is_upgraded,string"`
+	PromotionalOfferID    string              `json:"promotional_offer_id"`
+	SubscriptionGroupID   string              `json:"subscription_group_identifier"`
+	WebOrderLineItemID    string              `json:"web_order_line_item_id"`
+	ExpiresDate           receipt.Millistamp  `json:"expires_date"`
 }
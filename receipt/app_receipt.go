@@ -0,0 +1,123 @@
+package receipt
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AppReceiptBody models the receipt object, which identifies the app a receipt belongs to. iOS 6
+// style receipts use the short bid, bvrs and item_id names for the same fields.
+// https://developer.apple.com/documentation/appstorereceipts/responsebody/receipt
+type AppReceiptBody struct {
+	AppItemID                  int64  `json:"app_item_id"`
+	ApplicationVersion         string `json:"application_version"`
+	BundleID                   string `json:"bundle_id"`
+	OriginalApplicationVersion string `json:"original_application_version"`
+	ReceiptType                string `json:"receipt_type"`
+
+	AdamID                    int64 `json:"adam_id"`
+	DownloadID                int64 `json:"download_id"`
+	VersionExternalIdentifier int64 `json:"version_external_identifier"`
+
+	// ReceiptCreationDate is when the App Store generated the receipt
+	ReceiptCreationDate  Millistamp `json:"receipt_creation_date_ms"`
+	RequestDate          Millistamp `json:"request_date_ms"`
+	OriginalPurchaseDate Millistamp `json:"original_purchase_date_ms"`
+	ExpirationDate       Millistamp `json:"expiration_date_ms,omitempty"`
+	PreorderDate         Millistamp `json:"preorder_date_ms,omitempty"`
+
+	// InApp lists every in-app purchase, including consumables and non-renewing subscriptions
+	// that latest_receipt_info leaves out
+	InApp []ReceiptInfoBody `json:"in_app,omitempty"`
+
+	Bid    string `json:"bid"`
+	Bvrs   string `json:"bvrs"`
+	ItemID string `json:"item_id"`
+}
+
+// decode unmarshals the receipt object, which rejects fields the receipt or its in-app purchases
+// don't have when strict
+func (body *AppReceiptBody) decode(data []byte, strict bool) error {
+	receipt := struct {
+		*AppReceiptBody
+		InApp json.RawMessage `json:"in_app"`
+
+		FormattedReceiptCreationDate  Millistamp `json:"receipt_creation_date"`
+		FormattedRequestDate          Millistamp `json:"request_date"`
+		FormattedOriginalPurchaseDate Millistamp `json:"original_purchase_date"`
+		FormattedExpirationDate       Millistamp `json:"expiration_date"`
+		FormattedPreorderDate         Millistamp `json:"preorder_date"`
+
+		// Apple repeats each date in Pacific time, which adds nothing to the other formats
+		ReceiptCreationDatePST  json.RawMessage `json:"receipt_creation_date_pst"`
+		RequestDatePST          json.RawMessage `json:"request_date_pst"`
+		OriginalPurchaseDatePST json.RawMessage `json:"original_purchase_date_pst"`
+		ExpirationDatePST       json.RawMessage `json:"expiration_date_pst"`
+		PreorderDatePST         json.RawMessage `json:"preorder_date_pst"`
+	}{AppReceiptBody: body}
+
+	if err := decodeJSON(data, &receipt, strict); err != nil {
+		return err
+	}
+
+	for _, date := range []struct {
+		ms        *Millistamp
+		formatted Millistamp
+	}{
+		{&body.ReceiptCreationDate, receipt.FormattedReceiptCreationDate},
+		{&body.RequestDate, receipt.FormattedRequestDate},
+		{&body.OriginalPurchaseDate, receipt.FormattedOriginalPurchaseDate},
+		{&body.ExpirationDate, receipt.FormattedExpirationDate},
+		{&body.PreorderDate, receipt.FormattedPreorderDate},
+	} {
+		if *date.ms == 0 {
+			*date.ms = date.formatted
+		}
+	}
+	if len(receipt.InApp) == 0 || string(receipt.InApp) == "null" {
+		return nil
+	}
+
+	infoList, err := decodeReceiptInfoList(receipt.InApp, strict)
+	if err != nil {
+		return err
+	}
+	body.InApp = infoList
+	return nil
+}
+
+// Receipt describes the app that a verified receipt belongs to
+type Receipt struct {
+	body AppReceiptBody
+}
+
+func (r Receipt) AppItemID() int64 {
+	return r.body.AppItemID
+}
+
+func (r Receipt) ApplicationVersion() string {
+	if r.body.ApplicationVersion == "" {
+		return r.body.Bvrs
+	}
+	return r.body.ApplicationVersion
+}
+
+func (r Receipt) BundleID() string {
+	if r.body.BundleID == "" {
+		return r.body.Bid
+	}
+	return r.body.BundleID
+}
+
+func (r Receipt) OriginalApplicationVersion() string {
+	return r.body.OriginalApplicationVersion
+}
+
+func (r Receipt) ReceiptType() string {
+	return r.body.ReceiptType
+}
+
+// CreatedAt is when the App Store generated the receipt, or zero for iOS 6 style receipts
+func (r Receipt) CreatedAt() time.Time {
+	return r.body.ReceiptCreationDate.Time()
+}
@@ -0,0 +1,119 @@
+package receipt
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ReceiptResult pairs a receipt from a batch with its verification result or error
+type ReceiptResult struct {
+	Receipt string
+	Result  VerifyResult
+	Err     error
+}
+
+// VerifyBatch verifies receipts concurrently, with at most BatchWorkers requests in flight. The
+// results line up with the receipts, and one receipt failing doesn't stop the others. If the
+// context ends first, receipts not yet verified fail with the context's error, which
+// VerifyBatch also returns.
+func (c *Client) VerifyBatch(ctx context.Context, receipts []string) ([]ReceiptResult, error) {
+	results := make([]ReceiptResult, len(receipts))
+	indexes := make(chan int)
+
+	workers := c.BatchWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				result, err := c.VerifyContext(ctx, receipts[i])
+				results[i] = ReceiptResult{receipts[i], result, err}
+			}
+		}()
+	}
+
+	sent := 0
+	for sent < len(receipts) && ctx.Err() == nil {
+		select {
+		case indexes <- sent:
+			sent++
+		case <-ctx.Done():
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		for i := sent; i < len(receipts); i++ {
+			results[i] = ReceiptResult{Receipt: receipts[i], Err: err}
+		}
+		return results, err
+	}
+
+	return results, nil
+}
+
+// VerifyFromReader verifies newline delimited receipts as it reads them, such as from a file too
+// large to hold in memory, with at most BatchWorkers requests in flight. It sends each result to
+// out as soon as it's verified, so results may arrive out of order, and closes out when done. If
+// the context ends first, it stops reading and returns the context's error without sending the
+// remaining results.
+func (c *Client) VerifyFromReader(ctx context.Context, r io.Reader, out chan<- ReceiptResult) error {
+	defer close(out)
+
+	receipts := make(chan string)
+
+	workers := c.BatchWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for receipt := range receipts {
+				result, err := c.VerifyContext(ctx, receipt)
+				select {
+				case out <- ReceiptResult{receipt, result, err}:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	// Unlike bufio.Scanner, bufio.Reader has no limit on line length for unusually large receipts
+	reader := bufio.NewReader(r)
+	var readErr error
+	for ctx.Err() == nil {
+		line, err := reader.ReadString('\n')
+		if receipt := strings.TrimSpace(line); receipt != "" {
+			select {
+			case receipts <- receipt:
+			case <-ctx.Done():
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+	}
+	close(receipts)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return readErr
+}
@@ -0,0 +1,135 @@
+package receipt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newBatchServer(t *testing.T) *httptest.Server {
+	data, readErr := ioutil.ReadFile("testdata/response1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req VerifyReceiptRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.ReceiptData == "malformed" {
+			w.Write([]byte(`{"status":21002}`))
+			return
+		}
+		w.Write(data)
+	}))
+}
+
+func TestVerifyBatch(t *testing.T) {
+	srv := newBatchServer(t)
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	receipts := []string{"receipt1", "malformed", "receipt3", "receipt4", "receipt5"}
+	results, err := c.VerifyBatch(context.Background(), receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != len(receipts) {
+		t.Fatalf("Should return %d results, not %d", len(receipts), len(results))
+	}
+
+	for i, result := range results {
+		if result.Receipt != receipts[i] {
+			t.Errorf("Should pair result %d with %s, not %s", i, receipts[i], result.Receipt)
+		}
+		if result.Receipt == "malformed" {
+			if !errors.Is(result.Err, ErrReceiptMalformed) {
+				t.Errorf("Should fail malformed receipt, not %v", result.Err)
+			}
+		} else if result.Err != nil || result.Result.ProductID() != "year-premium" {
+			t.Errorf("Should verify %s, not %v", result.Receipt, result.Err)
+		}
+	}
+}
+
+func TestVerifyBatchCancelled(t *testing.T) {
+	srv := newBatchServer(t)
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := c.VerifyBatch(ctx, []string{"receipt1", "receipt2"})
+	if err != context.Canceled {
+		t.Errorf("Should return context error, not %v", err)
+	}
+
+	for _, result := range results {
+		if result.Err == nil {
+			t.Errorf("Should fail %s after cancelling", result.Receipt)
+		}
+	}
+}
+
+func TestVerifyFromReader(t *testing.T) {
+	srv := newBatchServer(t)
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	out := make(chan ReceiptResult)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.VerifyFromReader(context.Background(), strings.NewReader("receipt1\nmalformed\n\nreceipt3\r\nreceipt4"), out)
+	}()
+
+	verified := make(map[string]bool)
+	for result := range out {
+		if result.Receipt == "malformed" {
+			if !errors.Is(result.Err, ErrReceiptMalformed) {
+				t.Errorf("Should fail malformed receipt, not %v", result.Err)
+			}
+		} else if result.Err != nil || result.Result.ProductID() != "year-premium" {
+			t.Errorf("Should verify %s, not %v", result.Receipt, result.Err)
+		}
+		verified[result.Receipt] = true
+	}
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if len(verified) != 4 || !verified["receipt3"] || !verified["receipt4"] {
+		t.Errorf("Should verify every receipt line once, not %v", verified)
+	}
+}
+
+func TestVerifyFromReaderCancelled(t *testing.T) {
+	srv := newBatchServer(t)
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	out := make(chan ReceiptResult)
+	if err := c.VerifyFromReader(ctx, strings.NewReader("receipt1\nreceipt2\n"), out); err != context.Canceled {
+		t.Errorf("Should return context error, not %v", err)
+	}
+	if _, ok := <-out; ok {
+		t.Error("Should close the channel without results after cancelling")
+	}
+}
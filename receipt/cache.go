@@ -0,0 +1,113 @@
+package receipt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Cache holds verified results so that resubmitted receipts skip the App Store. Implementations
+// backed by a shared store like Redis can encode results with VerifyResult's MarshalJSON.
+type Cache interface {
+
+	// Get returns the result stored for the key, or false if there is none or it expired
+	Get(key string) (VerifyResult, bool)
+
+	// Set stores the result for the key until the TTL passes
+	Set(key string, result VerifyResult, ttl time.Duration)
+}
+
+// CacheInvalidator is a Cache that can evict every result for a subscription once it changes. With
+// App Store Server Notifications v2, decoding notifications with the Client's DecodeNotification
+// evicts the transaction's results, so that refunds and renewals take effect right away. Servers
+// handling other notifications can call the Client's InvalidateCache.
+type CacheInvalidator interface {
+	InvalidateByOriginalTransactionID(originalTransactionID string)
+}
+
+// InvalidateCache evicts the results for the subscription or purchase from the Client's Cache when
+// it's a CacheInvalidator, such as MemoryCache
+func (c *Client) InvalidateCache(originalTransactionID string) {
+	if invalidator, ok := c.Cache.(CacheInvalidator); ok && originalTransactionID != "" {
+		invalidator.InvalidateByOriginalTransactionID(originalTransactionID)
+	}
+}
+
+// cacheKey hashes the receipt, which can be tens of kilobytes, into a short key
+func cacheKey(receipt string) string {
+	sum := sha256.Sum256([]byte(receipt))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheTTL keeps a result no longer than ttl, and no later than the subscription expires so
+// that the result doesn't stay active after it lapses
+func cacheTTL(result VerifyResult, ttl time.Duration, now time.Time) time.Duration {
+	if expiresAt := result.ExpiresAt(); expiresAt.After(now) && expiresAt.Sub(now) < ttl {
+		return expiresAt.Sub(now)
+	}
+	return ttl
+}
+
+type memoryCacheEntry struct {
+	result    VerifyResult
+	expiresAt time.Time
+}
+
+// MemoryCache is a Cache within the process, which suits a single server
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+	now     func() time.Time
+}
+
+// NewMemoryCache creates an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryCacheEntry), now: time.Now}
+}
+
+func (c *MemoryCache) Get(key string) (VerifyResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return VerifyResult{}, false
+	}
+	if !c.now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return VerifyResult{}, false
+	}
+	return entry.result, true
+}
+
+func (c *MemoryCache) Set(key string, result VerifyResult, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Sweep expired entries so receipts that are never resubmitted don't pile up
+	now := c.now()
+	for k, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = memoryCacheEntry{result, now.Add(ttl)}
+}
+
+// InvalidateByOriginalTransactionID evicts every result with a transaction of the subscription or
+// purchase, such as after a notification of a refund
+func (c *MemoryCache) InvalidateByOriginalTransactionID(originalTransactionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, entry := range c.entries {
+		for _, transaction := range entry.result.purchases() {
+			if transaction.OriginalTransactionID() == originalTransactionID {
+				delete(c.entries, k)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package receipt
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifyCache(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Cache = NewMemoryCache()
+	c.CacheTTL = time.Hour
+
+	for _, receipt := range []string{"receipt123", "receipt123", "receipt456"} {
+		if _, err := c.Verify(receipt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if attempts != 2 {
+		t.Errorf("Should have sent each receipt once, not %d requests", attempts)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Date(2019, time.April, 30, 0, 0, 0, 0, time.UTC)
+
+	expiring := MockResult(ReceiptInfoBody{ExpiresDate: newMillistamp(now.Add(time.Hour))})
+	if ttl := cacheTTL(expiring, 24*time.Hour, now); ttl != time.Hour {
+		t.Errorf("Should keep the result until it expires, not %s", ttl)
+	}
+	if ttl := cacheTTL(expiring, time.Minute, now); ttl != time.Minute {
+		t.Errorf("Should keep the result for the TTL, not %s", ttl)
+	}
+
+	expired := MockResult(ReceiptInfoBody{ExpiresDate: newMillistamp(now.Add(-time.Hour))})
+	if ttl := cacheTTL(expired, time.Minute, now); ttl != time.Minute {
+		t.Errorf("Should keep an expired result for the TTL, not %s", ttl)
+	}
+}
+
+func TestMemoryCacheExpires(t *testing.T) {
+	now := time.Date(2019, time.April, 30, 0, 0, 0, 0, time.UTC)
+	cache := NewMemoryCache()
+	cache.now = func() time.Time { return now }
+
+	cache.Set("key123", MockResult(ReceiptInfoBody{TransactionID: "123456789012345"}), time.Minute)
+	if result, ok := cache.Get("key123"); !ok || result.TransactionID() != "123456789012345" {
+		t.Errorf("Should return the result before the TTL passes, not %v", result)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get("key123"); ok {
+		t.Error("Should not return the result once the TTL passes")
+	}
+	if _, ok := cache.Get("key456"); ok {
+		t.Error("Should not return a result for a missing key")
+	}
+}
+
+// ttlCache records the TTL of each result it stores
+type ttlCache struct {
+	ttls map[string]time.Duration
+}
+
+func (c ttlCache) Get(key string) (VerifyResult, bool) {
+	return VerifyResult{}, false
+}
+
+func (c ttlCache) Set(key string, result VerifyResult, ttl time.Duration) {
+	c.ttls[key] = ttl
+}
+
+func TestVerifyCacheUsesNow(t *testing.T) {
+	srv := newTestServer(t, "response5.json")
+	defer srv.Close()
+
+	cache := ttlCache{make(map[string]time.Duration)}
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Cache = cache
+	c.CacheTTL = 24 * time.Hour
+	c.Now = func() time.Time { return time.Date(2019, time.April, 30, 23, 0, 0, 0, time.UTC) }
+
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := cache.ttls[cacheKey("receipt123")]; ttl != time.Hour {
+		t.Errorf("Should keep the result until it expires an hour from now, not %s", ttl)
+	}
+}
+
+func TestMemoryCacheInvalidate(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("key123", MockResult(ReceiptInfoBody{OriginalTransactionID: "2000000000000001"}), time.Hour)
+	cache.Set("key456", MockResult(ReceiptInfoBody{OriginalTransactionID: "3000000000000001"}), time.Hour)
+
+	cache.InvalidateByOriginalTransactionID("2000000000000001")
+
+	if _, ok := cache.Get("key123"); ok {
+		t.Error("Should evict the subscription's result")
+	}
+	if _, ok := cache.Get("key456"); !ok {
+		t.Error("Should keep results for other subscriptions")
+	}
+}
+
+func TestDecodeNotificationInvalidatesCache(t *testing.T) {
+	signer := newTestSigner(t)
+
+	c := New("password")
+	c.Cache = NewMemoryCache()
+	c.RootCertificates = signer.roots
+	c.Now = func() time.Time { return jwsTestTime }
+
+	c.Cache.Set("key123", MockResult(ReceiptInfoBody{OriginalTransactionID: "2000000000000001"}), time.Hour)
+
+	_, err := c.DecodeNotification(signer.sign(t, map[string]interface{}{
+		"notificationType": "REFUND",
+		"data": map[string]interface{}{
+			"signedTransactionInfo": signer.sign(t, map[string]interface{}{
+				"transactionId":         "2000000000000002",
+				"originalTransactionId": "2000000000000001",
+			}),
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Cache.Get("key123"); ok {
+		t.Error("Should evict the result for the notification's transaction")
+	}
+}
@@ -0,0 +1,474 @@
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client verifies App Store receipts using your app's shared secret.
+type Client struct {
+
+	// HTTPClient sends verifyReceipt requests, or a shared default client when nil
+	HTTPClient *http.Client
+
+	// Header adds headers to every verifyReceipt request, such as for a proxy, though the
+	// Content-Type stays application/json
+	Header http.Header
+
+	// UserAgent identifies your server in every request to the App Store, such as to tell its
+	// traffic apart when debugging a proxy, in place of any User-Agent in Header
+	UserAgent string
+
+	// Timeout bounds each verifyReceipt request unless the context ends sooner, or zero for none
+	Timeout time.Duration
+
+	// ProductionURL and SandboxURL locate the verifyReceipt endpoints, such as a local mock. Verify
+	// refuses endpoints that don't use HTTPS, other than on the loopback interface.
+	ProductionURL string
+	SandboxURL    string
+
+	// SandboxOnly skips the production endpoint and verifies every receipt with the sandbox, such
+	// as for development and test receipts
+	SandboxOnly bool
+
+	// StrictProduction fails with ErrReceiptFromTest instead of falling back to the sandbox, since
+	// a sandbox receipt in production can mean a spoofed client. Verifying fails outright when
+	// it's combined with SandboxOnly or SandboxFirst, which would verify with the sandbox anyway.
+	StrictProduction bool
+
+	// EnvironmentOrder is which environment verifies receipts first, falling back to the other
+	// when the receipt is from there, such as SandboxFirst for QA builds. Apple recommends the
+	// default, ProductionFirst.
+	EnvironmentOrder EnvironmentOrder
+
+	// APIKey signs App Store Server API requests, such as from GetTransactionInfo
+	APIKey *APIKey
+
+	// APIProductionURL and APISandboxURL locate the App Store Server API
+	APIProductionURL string
+	APISandboxURL    string
+
+	// RootCertificates are trusted in place of the Apple root CA when verifying JWS signed
+	// transactions, renewal info and notifications, such as a test root that signs fake chains.
+	// Chains still need Apple's signing and intermediate certificate markers.
+	RootCertificates *x509.CertPool
+
+	// IncludeOldTransactions asks for every renewal in latest_receipt_info rather than only the
+	// latest, such as to show billing history with AllTransactions, at the cost of a response
+	// that grows with each renewal
+	IncludeOldTransactions bool
+
+	// KeepRawResponse returns the App Store's JSON as RawResponse with each VerifyResult, which
+	// holds on to the whole response for as long as the result
+	KeepRawResponse bool
+
+	// StrictDecode fails responses with fields the package doesn't know, such as to catch changes
+	// to Apple's response format when testing against recorded responses. Apple adds fields over
+	// time, so production servers should leave it off.
+	StrictDecode bool
+
+	// Cache returns results for receipts verified within CacheTTL, or before the subscription
+	// expires if that's sooner, instead of asking the App Store again
+	Cache    Cache
+	CacheTTL time.Duration
+
+	// Store saves results verified with VerifyAndStore when set
+	Store Store
+
+	// SeenTransactions lets VerifyOnce refuse transactions that were already handled when set
+	SeenTransactions SeenTransactions
+
+	// RateLimit bounds verifyReceipt requests per second, including retries, with bursts of up to
+	// RateBurst, so that busy servers don't get throttled by the App Store. Requests wait their
+	// turn unless RateLimitFailFast, which fails them with ErrRateLimited instead. Zero means no
+	// limit. Changes after the first request have no effect.
+	RateLimit         float64
+	RateBurst         int
+	RateLimitFailFast bool
+
+	// Now tells the time for cache lifetimes, certificate validity, App Store Server API tokens
+	// and the Retry policy's MaxElapsed, such as a fixed time in tests, or time.Now when nil.
+	// Timeouts and rate limits wait in real time, so they keep to the wall clock.
+	Now func() time.Time
+
+	// Sleep waits between retries until the delay passes or the context ends, such as to advance
+	// a fake clock in tests, or waits in real time when nil
+	Sleep func(ctx context.Context, delay time.Duration) error
+
+	// Retry resends receipts after network errors and transient App Store statuses
+	Retry RetryPolicy
+
+	// ExpectedBundleID rejects receipts from other apps when set
+	ExpectedBundleID string
+
+	// Observer receives the latency and status of every verifyReceipt request when set
+	Observer Observer
+
+	// OnSuspiciousReceipt receives receipts the App Store reports as malformed or unauthenticated,
+	// such as to flag the account for fraud review. Verify calls it before returning, so it
+	// should hand off any slow work.
+	OnSuspiciousReceipt func(status int, receipt string)
+
+	// BatchWorkers limits how many receipts VerifyBatch verifies at once
+	BatchWorkers int
+
+	secretMu sync.RWMutex
+	secret   string
+
+	limiterOnce sync.Once
+	limiter     *rateLimiter
+}
+
+var defaultHTTPClient = &http.Client{}
+
+// EnvironmentOrder is which environment the Client tries first
+type EnvironmentOrder int
+
+// Environment orders for verifying receipts
+const (
+	ProductionFirst EnvironmentOrder = iota
+	SandboxFirst
+)
+
+// New creates a Client that verifies receipts with the App Store shared secret.
+func New(sharedSecret string) *Client {
+	return &Client{
+		ProductionURL:    DefaultEndpoints.ProductionURL,
+		SandboxURL:       DefaultEndpoints.SandboxURL,
+		APIProductionURL: DefaultEndpoints.APIProductionURL,
+		APISandboxURL:    DefaultEndpoints.APISandboxURL,
+		Timeout:          time.Second * 20, // 20 second timeout
+		UserAgent:        defaultUserAgent,
+		Now:              time.Now,
+		Retry:            DefaultRetryPolicy,
+		BatchWorkers:     4,
+		secret:           sharedSecret,
+	}
+}
+
+// Verify sends the base64 encoded receipt data to the App Store and returns the result,
+// falling back to the sandbox when the receipt came from the test environment, or to production
+// for SandboxFirst.
+func (c *Client) Verify(receipt string) (VerifyResult, error) {
+	return c.VerifyContext(context.Background(), receipt)
+}
+
+// VerifyContext is Verify with a context that can cancel requests and retries.
+func (c *Client) VerifyContext(ctx context.Context, receipt string) (VerifyResult, error) {
+
+	secret := c.sharedSecret()
+	if secret == "" {
+		return VerifyResult{}, errors.New("itunes.appSharedSecret should have been set")
+	}
+
+	if err := c.checkEnvironments(); err != nil {
+		return VerifyResult{}, err
+	}
+
+	var key string
+	if c.Cache != nil && c.CacheTTL > 0 {
+		key = cacheKey(receipt)
+		if result, ok := c.Cache.Get(key); ok {
+			return result, nil
+		}
+	}
+
+	req := VerifyReceiptRequest{
+		ReceiptData:            receipt,
+		Password:               secret,
+		ExcludeOldTransactions: !c.IncludeOldTransactions,
+	}
+
+	buf := new(bytes.Buffer)
+
+	encoder := json.NewEncoder(buf)
+	if encodeErr := encoder.Encode(&req); encodeErr != nil {
+		log.Println("Should have encoded verifyReceipt request", receipt)
+		return VerifyResult{}, encodeErr
+	}
+
+	// Copy encoded data to a bytes.Reader to support multiple read passes
+	postData := bytes.NewReader(buf.Bytes())
+
+	// According to https://developer.apple.com/library/ios/technotes/tn2259/_index.html#//apple_ref/doc/uid/DTS40009578-CH1-ITUNES_CONNECT
+	// the correct way to verify is to try the prod verify url, and if that fails, then try the
+	// sandbox url.
+	verifyURL, env := c.ProductionURL, EnvironmentProduction
+	fallbackURL, fallbackEnv, fallbackErr := c.SandboxURL, EnvironmentSandbox, ErrReceiptFromTest
+	if c.EnvironmentOrder == SandboxFirst {
+		verifyURL, env = c.SandboxURL, EnvironmentSandbox
+		fallbackURL, fallbackEnv, fallbackErr = c.ProductionURL, EnvironmentProduction, ErrReceiptFromProd
+	}
+	if c.SandboxOnly {
+		verifyURL, env = c.SandboxURL, EnvironmentSandbox
+	}
+	for _, endpoint := range []string{verifyURL, fallbackURL} {
+		if err := checkEndpoint(endpoint); err != nil {
+			return VerifyResult{}, err
+		}
+	}
+
+	result, err := c.send(ctx, verifyURL, env, secret, postData)
+	if err == fallbackErr && !c.SandboxOnly && !(c.StrictProduction && fallbackEnv == EnvironmentSandbox) {
+		result, err = c.send(ctx, fallbackURL, fallbackEnv, secret, postData)
+		env = fallbackEnv
+	}
+	if statusErr, ok := err.(StatusError); ok && c.OnSuspiciousReceipt != nil {
+		if statusErr.Status == StatusReceiptMalformed || statusErr.Status == StatusNotAuthenticated {
+			c.OnSuspiciousReceipt(statusErr.Status, receipt)
+		}
+	}
+	if err != nil {
+		return VerifyResult{RawResponse: result.RawResponse}, wrapVerifyError(err, result, receipt)
+	}
+
+	if bundleID := result.Receipt.BundleID(); c.ExpectedBundleID != "" && bundleID != c.ExpectedBundleID {
+		return VerifyResult{RawResponse: result.RawResponse}, wrapVerifyError(
+			BundleIDError{Expected: c.ExpectedBundleID, BundleID: bundleID}, result, receipt)
+	}
+
+	// Older responses leave out the environment, so go by which endpoint verified the receipt
+	if result.Environment == "" {
+		result.Environment = env
+	}
+
+	if key != "" {
+		if ttl := cacheTTL(result, c.CacheTTL, c.now()); ttl > 0 {
+			c.Cache.Set(key, result, ttl)
+		}
+	}
+
+	return result, nil
+}
+
+// checkEnvironments refuses to let SandboxOnly or SandboxFirst send receipts to the sandbox that
+// StrictProduction keeps out of it
+func (c *Client) checkEnvironments() error {
+	if c.StrictProduction && (c.SandboxOnly || c.EnvironmentOrder == SandboxFirst) {
+		return errors.New("StrictProduction should not be combined with SandboxOnly or SandboxFirst")
+	}
+	return nil
+}
+
+// VerifyBytes is VerifyContext for a receipt uploaded as either the binary file from the app
+// bundle or base64 text, which it tells apart so that either can be sent to the App Store.
+func (c *Client) VerifyBytes(ctx context.Context, receiptData []byte) (VerifyResult, error) {
+	if isSignedData(receiptData) {
+		return c.VerifyContext(ctx, base64.StdEncoding.EncodeToString(receiptData))
+	}
+
+	// Base64 text uploads often end with a newline or wrap lines
+	return c.VerifyContext(ctx, strings.Join(strings.Fields(string(receiptData)), ""))
+}
+
+// Refresh verifies the LatestReceipt from a previous result, so that the App Store's freshest
+// receipt is always the one being checked. Without a LatestReceipt it returns prev unchanged.
+func (c *Client) Refresh(ctx context.Context, prev VerifyResult) (VerifyResult, error) {
+	if prev.LatestReceipt == "" {
+		return prev, nil
+	}
+	return c.VerifyContext(ctx, prev.LatestReceipt)
+}
+
+// SetSharedSecret replaces the App Store shared secret, such as after rotating it, without
+// disturbing receipts being verified concurrently
+func (c *Client) SetSharedSecret(secret string) {
+	c.secretMu.Lock()
+	defer c.secretMu.Unlock()
+	c.secret = secret
+}
+
+func (c *Client) sharedSecret() string {
+	c.secretMu.RLock()
+	defer c.secretMu.RUnlock()
+	return c.secret
+}
+
+// BundleIDError reports a receipt that belongs to a different app than the Client expects
+type BundleIDError struct {
+	Expected string
+	BundleID string
+}
+
+func (e BundleIDError) Error() string {
+	return fmt.Sprintf("Receipt is for bundle ID %q instead of %q", e.BundleID, e.Expected)
+}
+
+// VerifyError reports which subscription or purchase a receipt that failed to verify is for, so
+// that failures can be traced to customers without logging the receipt itself
+type VerifyError struct {
+	OriginalTransactionID string
+	Status                int
+	Err                   error
+}
+
+func (e VerifyError) Error() string {
+	return fmt.Sprintf("Receipt for original transaction %s with status %d failed to verify: %v",
+		e.OriginalTransactionID, e.Status, e.Err)
+}
+
+func (e VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// wrapVerifyError adds the original transaction ID to err when either the App Store response or
+// the receipt itself reveals it, and otherwise returns err as it is
+func wrapVerifyError(err error, result VerifyResult, receipt string) error {
+	var transactions []Transaction
+	if result.Info != nil {
+		transactions = result.purchases()
+	} else if local, parseErr := ParseReceipt(receipt); parseErr == nil {
+		transactions = local.AllTransactions()
+	}
+
+	var originalTransactionID string
+	for i := len(transactions) - 1; i >= 0 && originalTransactionID == ""; i-- {
+		originalTransactionID = transactions[i].OriginalTransactionID()
+	}
+	if originalTransactionID == "" {
+		return err
+	}
+
+	status := StatusNoResponse
+	if statusErr, ok := err.(StatusError); ok {
+		status = statusErr.Status
+	} else if result.Info != nil {
+		status = result.Status()
+	}
+
+	return VerifyError{OriginalTransactionID: originalTransactionID, Status: status, Err: err}
+}
+
+// send posts the receipt to a verifyReceipt endpoint, retrying with exponential backoff after
+// network errors and while the App Store reports a transient status. Once the retry policy or the
+// context deadline leaves no time for another attempt, it returns the last error. The secret is
+// the one encoded in postData, which is redacted from errors.
+func (c *Client) send(ctx context.Context, verifyURL, env, secret string,
+	postData *bytes.Reader) (VerifyResult, error) {
+
+	// Keep both time budgets by the Client's clock, counting what's left of the context's deadline
+	// in real time
+	now := c.now()
+	deadline := time.Time{}
+	if c.Retry.MaxElapsed > 0 {
+		deadline = now.Add(c.Retry.MaxElapsed)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok {
+		if ctxDeadline := now.Add(time.Until(ctxDeadline)); deadline.IsZero() || ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		if _, err := postData.Seek(0, io.SeekStart); err != nil {
+			return VerifyResult{}, err
+		}
+
+		if err := c.throttle(ctx); err != nil {
+			return VerifyResult{}, err
+		}
+
+		start := time.Now()
+		var result VerifyResult
+		var err error
+		data, sendErr := c.sendOnce(ctx, verifyURL, secret, postData)
+		if sendErr != nil {
+			c.observe(StatusNoResponse, env, start)
+			err = sendErr
+
+			// Stop when the caller cancels rather than because of a network error
+			if ctx.Err() != nil {
+				return VerifyResult{}, err
+			}
+			if httpErr, ok := sendErr.(HTTPError); ok && !httpErr.Temporary() {
+				return VerifyResult{}, err
+			}
+		} else {
+			var parseErr error
+			result, parseErr = decodeReceiptResponse(data, c.StrictDecode)
+			if c.KeepRawResponse {
+				result.RawResponse = data
+			}
+			if statusErr, ok := parseErr.(StatusError); ok {
+				c.observe(statusErr.Status, env, start)
+				if !statusErr.Temporary() {
+					return result, parseErr
+				}
+			} else if parseErr != nil {
+				c.observe(StatusNoResponse, env, start)
+				return result, parseErr
+			} else {
+				c.observe(result.Status(), env, start)
+				return result, nil
+			}
+			err = parseErr
+		}
+
+		delay := c.Retry.delay(attempt, err)
+		if attempt >= c.Retry.attempts(err) || (!deadline.IsZero() && c.now().Add(delay).After(deadline)) {
+			return result, err
+		}
+
+		log.Println("Retry verifyReceipt after", delay, err)
+		if c.sleep(ctx, delay) != nil {
+			return result, err
+		}
+	}
+}
+
+func (c *Client) sleep(ctx context.Context, delay time.Duration) error {
+	if c.Sleep != nil {
+		return c.Sleep(ctx, delay)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendOnce posts the receipt a single time within the Client's Timeout
+func (c *Client) sendOnce(ctx context.Context, verifyURL, secret string, postData *bytes.Reader) ([]byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	return sendReceiptRequest(ctx, c.httpClient(), verifyURL, c.Header, c.UserAgent, secret, postData)
+}
+
+func (c *Client) observe(status int, env string, start time.Time) {
+	if c.Observer != nil {
+		c.Observer.ObserveVerify(status, env, time.Since(start))
+	}
+}
+
+func (c *Client) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return defaultHTTPClient
+}
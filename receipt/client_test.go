@@ -0,0 +1,1200 @@
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// redirectTransport sends every request to the test server regardless of the requested URL
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newTestServer(t *testing.T, fileName string) *httptest.Server {
+	data, readErr := ioutil.ReadFile("testdata/" + fileName)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+}
+
+func TestVerifyRequiresSecret(t *testing.T) {
+	if _, err := New("").Verify("receipt123"); err == nil {
+		t.Error("Should have refused to verify without a shared secret")
+	}
+}
+
+func TestVerifyWithHTTPClient(t *testing.T) {
+	srv := newTestServer(t, "response1.json")
+	defer srv.Close()
+
+	target, _ := url.Parse(srv.URL)
+
+	c := New("password")
+	c.HTTPClient = &http.Client{Transport: redirectTransport{target}}
+
+	info, err := c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Date(2015, time.May, 23, 17, 05, 59, 0, time.UTC)
+	if !info.ExpiresAt().Equal(expiresAt) {
+		t.Errorf("Should parse %s as %s", info.ExpiresAt(), expiresAt)
+	}
+
+	if info.Environment != EnvironmentProduction {
+		t.Errorf("Should fill in missing environment as Production, not %q", info.Environment)
+	}
+}
+
+func TestVerifyFallsBackToSandbox(t *testing.T) {
+	prod := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":21007}`))
+	}))
+	defer prod.Close()
+
+	sandbox := newTestServer(t, "response2.json")
+	defer sandbox.Close()
+
+	c := New("password")
+	c.ProductionURL = prod.URL
+	c.SandboxURL = sandbox.URL
+
+	info, err := c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Date(2019, time.August, 20, 04, 28, 57, 0, time.UTC)
+	if !info.ExpiresAt().Equal(expiresAt) {
+		t.Errorf("Should parse %s as %s", info.ExpiresAt(), expiresAt)
+	}
+
+	if info.Environment != EnvironmentSandbox {
+		t.Errorf("Should fill in missing environment as Sandbox, not %q", info.Environment)
+	}
+}
+
+func TestVerifyRetriesTransientStatus(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.Write([]byte(`{"status":21005}`))
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Retry.BaseDelay = time.Millisecond
+
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Error(err)
+	}
+	if attempts != 3 {
+		t.Errorf("Should have sent receipt 3 times, not %d", attempts)
+	}
+}
+
+func TestVerifyStopsRetryingAfterMaxAttempts(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Write([]byte(`{"status":21000}`))
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Retry.MaxAttempts = 2
+	c.Retry.BaseDelay = time.Millisecond
+
+	if _, err := c.Verify("receipt123"); err == nil {
+		t.Error("Should have failed when the App Store stays unreadable")
+	}
+	if attempts != 2 {
+		t.Errorf("Should have sent receipt 2 times, not %d", attempts)
+	}
+}
+
+func TestVerifyDoesNotRetryMismatchedSecret(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Write([]byte(`{"status":21004}`))
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Retry.BaseDelay = time.Millisecond
+
+	if _, err := c.Verify("receipt123"); err == nil {
+		t.Error("Should have failed with a mismatched secret")
+	}
+	if attempts != 1 {
+		t.Errorf("Should have sent receipt once, not %d", attempts)
+	}
+}
+
+func TestVerifyRetriesInternalDataAccessError(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Write([]byte(`{"status":21101}`))
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Retry.InternalDataAccessDelay = time.Millisecond
+
+	if _, err := c.Verify("receipt123"); !errors.Is(err, ErrInternalDataAccess) {
+		t.Errorf("Should fail with internal data access error, not %v", err)
+	}
+	if attempts != c.Retry.MaxAttempts {
+		t.Errorf("Should have sent receipt %d times, not %d", c.Retry.MaxAttempts, attempts)
+	}
+}
+
+func TestVerifyExpectedBundleID(t *testing.T) {
+	srv := newTestServer(t, "response4.json")
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.ExpectedBundleID = "com.example.superscribe"
+
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Errorf("Should accept receipt for the expected bundle ID, not %v", err)
+	}
+
+	c.ExpectedBundleID = "com.example.other"
+
+	_, err := c.Verify("receipt123")
+	var bundleErr BundleIDError
+	if !errors.As(err, &bundleErr) || bundleErr.BundleID != "com.example.superscribe" {
+		t.Errorf("Should reject receipt for another bundle ID, not %v", err)
+	}
+
+	var verifyErr VerifyError
+	if !errors.As(err, &verifyErr) || verifyErr.OriginalTransactionID != "123456789012345" ||
+		verifyErr.Status != StatusValid {
+		t.Errorf("Should report the original transaction of the other app's receipt, not %v", err)
+	}
+}
+
+func TestVerifyErrorOriginalTransactionID(t *testing.T) {
+	srv := newTestServer(t, "response11.json")
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	data, _ := newTestLocalReceipt(t)
+	receipt := base64.StdEncoding.EncodeToString(data)
+
+	_, err := c.Verify(receipt)
+	var verifyErr VerifyError
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("Should fail with a VerifyError, not %v", err)
+	}
+	if verifyErr.OriginalTransactionID != "123456789012345" || verifyErr.Status != StatusUnauthorized {
+		t.Errorf("Should report the receipt's original transaction and status, not %s %d",
+			verifyErr.OriginalTransactionID, verifyErr.Status)
+	}
+	if !errors.Is(err, ErrReceiptUnauthorized) {
+		t.Errorf("Should wrap the App Store's error, not %v", verifyErr.Err)
+	}
+	if strings.Contains(err.Error(), receipt) {
+		t.Error("Should leave the receipt out of the error message")
+	}
+
+	// Without a readable receipt there's no original transaction to report
+	if _, err := c.Verify("receipt123"); err != ErrReceiptUnauthorized {
+		t.Errorf("Should return the App Store's error as it is, not %v", err)
+	}
+}
+
+// newSlowServer never responds until the test closes release
+func newSlowServer(release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"status":21000}`))
+	}))
+}
+
+func TestVerifyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := newSlowServer(release)
+	defer srv.Close()
+	defer close(release)
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Timeout = 10 * time.Millisecond
+	c.Retry.MaxAttempts = 1
+
+	start := time.Now()
+	if _, err := c.Verify("receipt123"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Should time out, not %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Should give up after the timeout, not %s", elapsed)
+	}
+}
+
+func TestVerifyContextDeadlineBeforeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := newSlowServer(release)
+	defer srv.Close()
+	defer close(release)
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := c.VerifyContext(ctx, "receipt123"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Should time out, not %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Should give up at the context deadline, not %s", elapsed)
+	}
+}
+
+func TestVerifySandboxOnly(t *testing.T) {
+	prodAttempts := 0
+	prod := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prodAttempts++
+		w.Write([]byte(`{"status":21007}`))
+	}))
+	defer prod.Close()
+
+	sandbox := newTestServer(t, "response2.json")
+	defer sandbox.Close()
+
+	c := New("password")
+	c.ProductionURL = prod.URL
+	c.SandboxURL = sandbox.URL
+	c.SandboxOnly = true
+
+	info, err := c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prodAttempts != 0 {
+		t.Errorf("Should skip production, not send receipt %d times", prodAttempts)
+	}
+	if info.Environment != EnvironmentSandbox {
+		t.Errorf("Should fill in missing environment as Sandbox, not %q", info.Environment)
+	}
+}
+
+func TestVerifyStrictProduction(t *testing.T) {
+	prod := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":21007}`))
+	}))
+	defer prod.Close()
+
+	sandboxAttempts := 0
+	sandbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sandboxAttempts++
+		w.Write([]byte(`{"status":0}`))
+	}))
+	defer sandbox.Close()
+
+	c := New("password")
+	c.ProductionURL = prod.URL
+	c.SandboxURL = sandbox.URL
+	c.StrictProduction = true
+
+	if _, err := c.Verify("receipt123"); !errors.Is(err, ErrReceiptFromTest) {
+		t.Errorf("Should reject sandbox receipt, not %v", err)
+	}
+	if sandboxAttempts != 0 {
+		t.Errorf("Should not fall back to sandbox, not send receipt %d times", sandboxAttempts)
+	}
+}
+
+func TestVerifySandboxFirst(t *testing.T) {
+	prodAttempts := 0
+	prod := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prodAttempts++
+		w.Write([]byte(`{"status":0,"receipt":{"product_id":"month-premium"}}`))
+	}))
+	defer prod.Close()
+
+	sandboxStatus := 0
+	sandbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status":%d,"receipt":{"product_id":"month-basic"}}`, sandboxStatus)
+	}))
+	defer sandbox.Close()
+
+	c := New("password")
+	c.ProductionURL = prod.URL
+	c.SandboxURL = sandbox.URL
+	c.EnvironmentOrder = SandboxFirst
+
+	result, err := c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prodAttempts != 0 || result.Environment != EnvironmentSandbox {
+		t.Errorf("Should verify with the sandbox first, not %q after %d production attempts",
+			result.Environment, prodAttempts)
+	}
+
+	sandboxStatus = StatusReceiptFromProd
+	result, err = c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prodAttempts != 1 || result.Environment != EnvironmentProduction || result.ProductID() != "month-premium" {
+		t.Errorf("Should fall back to production, not %q after %d production attempts",
+			result.Environment, prodAttempts)
+	}
+}
+
+func TestVerifyStrictProductionRejectsSandbox(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Write([]byte(`{"status":0,"receipt":{"product_id":"month-premium"}}`))
+	}))
+	defer srv.Close()
+
+	for _, configure := range []func(c *Client){
+		func(c *Client) { c.SandboxOnly = true },
+		func(c *Client) { c.EnvironmentOrder = SandboxFirst },
+	} {
+		c := New("password")
+		c.ProductionURL = srv.URL
+		c.SandboxURL = srv.URL
+		c.StrictProduction = true
+		configure(c)
+
+		if _, err := c.Verify("receipt123"); err == nil {
+			t.Error("Should refuse to verify with the sandbox in strict production")
+		}
+
+		c.APIKey = newTestAPIKey(t)
+		c.APIProductionURL = srv.URL
+		c.APISandboxURL = srv.URL
+		if _, err := c.GetTransactionInfo(context.Background(), "2000000000000002"); err == nil {
+			t.Error("Should refuse to call the sandbox App Store Server API in strict production")
+		}
+	}
+	if attempts != 0 {
+		t.Errorf("Should not send anything, not %d requests", attempts)
+	}
+}
+
+func TestVerifyObserver(t *testing.T) {
+	prod := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":21007}`))
+	}))
+	defer prod.Close()
+
+	sandbox := newTestServer(t, "response2.json")
+	defer sandbox.Close()
+
+	type observation struct {
+		status int
+		env    string
+	}
+	var observations []observation
+
+	c := New("password")
+	c.ProductionURL = prod.URL
+	c.SandboxURL = sandbox.URL
+	c.Observer = ObserverFunc(func(status int, env string, d time.Duration) {
+		observations = append(observations, observation{status, env})
+	})
+
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []observation{
+		{StatusReceiptFromTest, EnvironmentProduction},
+		{StatusValid, EnvironmentSandbox},
+	}
+	if len(observations) != len(expected) {
+		t.Fatalf("Should observe %v, not %v", expected, observations)
+	}
+	for i := range expected {
+		if observations[i] != expected[i] {
+			t.Errorf("Should observe %v, not %v", expected[i], observations[i])
+		}
+	}
+}
+
+// failingTransport fails every request as if the network were down
+type failingTransport struct {
+	attempts *int
+}
+
+func (ft failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	*ft.attempts++
+	return nil, errors.New("network is unreachable")
+}
+
+func TestVerifyNetworkError(t *testing.T) {
+	attempts := 0
+
+	c := New("password")
+	c.HTTPClient = &http.Client{Transport: failingTransport{&attempts}}
+	c.Retry.BaseDelay = time.Millisecond
+
+	_, err := c.Verify("receipt123")
+	if urlErr, ok := err.(*url.Error); !ok || urlErr.Err.Error() != "network is unreachable" {
+		t.Errorf("Should return the network error, not %v", err)
+	}
+	if attempts != c.Retry.MaxAttempts {
+		t.Errorf("Should have sent receipt %d times, not %d", c.Retry.MaxAttempts, attempts)
+	}
+}
+
+func TestVerifyStopsRetryingAfterMaxElapsed(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Write([]byte(`{"status":21005}`))
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Retry = RetryPolicy{MaxAttempts: 10, BaseDelay: 20 * time.Millisecond, MaxElapsed: 50 * time.Millisecond}
+
+	if _, err := c.Verify("receipt123"); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("Should return the last error, not %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Should have run out of time after 2 attempts, not %d", attempts)
+	}
+}
+
+func TestVerifyStopsRetryingBeforeContextDeadline(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Write([]byte(`{"status":21005}`))
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.VerifyContext(ctx, "receipt123"); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("Should return the last error, not %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Should not wait past the context deadline, not send receipt %d times", attempts)
+	}
+}
+
+// fakeClock stands in for Client.Now and Client.Sleep, so that retries take no real time
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Sleep(ctx context.Context, delay time.Duration) error {
+	f.slept = append(f.slept, delay)
+	f.now = f.now.Add(delay)
+	return ctx.Err()
+}
+
+func TestVerifyRetriesWithClock(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	for _, test := range []struct {
+		name     string
+		failures int
+		policy   RetryPolicy
+		timeout  time.Duration
+		slept    []time.Duration
+		ok       bool
+	}{
+		{"within max elapsed", 2, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxElapsed: 10 * time.Second},
+			0, []time.Duration{time.Second, 2 * time.Second}, true},
+		{"beyond max elapsed", 2, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxElapsed: 2 * time.Second},
+			0, []time.Duration{time.Second}, false},
+		{"within context deadline", 3, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second},
+			time.Minute, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, true},
+		{"beyond context deadline", 3, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second},
+			5 * time.Second, []time.Duration{time.Second, 2 * time.Second}, false},
+		{"beyond max attempts", 3, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxElapsed: time.Minute},
+			0, []time.Duration{time.Second, 2 * time.Second}, false},
+	} {
+		attempts := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts <= test.failures {
+				w.Write([]byte(`{"status":21005}`))
+				return
+			}
+			w.Write(data)
+		}))
+
+		clock := &fakeClock{now: time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)}
+		c := New("password")
+		c.ProductionURL = srv.URL
+		c.Retry = test.policy
+		c.Now = clock.Now
+		c.Sleep = clock.Sleep
+
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if test.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, test.timeout)
+		}
+
+		_, err := c.VerifyContext(ctx, "receipt123")
+		if test.ok && err != nil {
+			t.Errorf("%s: Should succeed after %d failures, not %v", test.name, test.failures, err)
+		}
+		if !test.ok && !errors.Is(err, ErrUnreachable) {
+			t.Errorf("%s: Should return the last error, not %v", test.name, err)
+		}
+		if fmt.Sprint(clock.slept) != fmt.Sprint(test.slept) {
+			t.Errorf("%s: Should wait %v between attempts, not %v", test.name, test.slept, clock.slept)
+		}
+		if attempts != len(test.slept)+1 {
+			t.Errorf("%s: Should send receipt %d times, not %d", test.name, len(test.slept)+1, attempts)
+		}
+
+		cancel()
+		srv.Close()
+	}
+}
+
+func TestVerifyStopsSleepingWhenCancelled(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Write([]byte(`{"status":21005}`))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Retry = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}
+	c.Sleep = func(ctx context.Context, delay time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+
+	if _, err := c.VerifyContext(ctx, "receipt123"); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("Should return the last error, not %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Should stop retrying once cancelled, not send receipt %d times", attempts)
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, Jitter: 0.2}
+
+	for i := 0; i < 100; i++ {
+		if delay := policy.delay(2, nil); delay < 160*time.Millisecond || delay > 240*time.Millisecond {
+			t.Fatalf("Should wait 200ms ± 20%%, not %s", delay)
+		}
+	}
+}
+
+func TestSetSharedSecretWhileVerifying(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req VerifyReceiptRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Password != "old" && req.Password != "new") {
+			w.Write([]byte(`{"status":21004}`))
+			return
+		}
+		w.Write([]byte(`{"status":0,"latest_receipt_info":[{"product_id":"month-premium"}]}`))
+	}))
+	defer srv.Close()
+
+	c := New("old")
+	c.ProductionURL = srv.URL
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Verify("receipt123"); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				c.SetSharedSecret("new")
+			} else {
+				c.SetSharedSecret("old")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestVerifyRetriesHTTPServerError(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "<html>Service Unavailable</html>", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Retry.BaseDelay = time.Millisecond
+
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Error(err)
+	}
+	if attempts != 2 {
+		t.Errorf("Should have sent receipt 2 times, not %d", attempts)
+	}
+}
+
+func TestVerifyHTTPClientError(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, strings.Repeat("Not Found ", 100), http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Retry.BaseDelay = time.Millisecond
+
+	_, err := c.Verify("receipt123")
+	httpErr, ok := err.(HTTPError)
+	if !ok || httpErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Should fail with HTTP 404, not %v", err)
+	}
+	if len(httpErr.Body) != maxHTTPErrorBody || !strings.HasPrefix(httpErr.Body, "Not Found") {
+		t.Errorf("Should keep the start of the body, not %q", httpErr.Body)
+	}
+	if attempts != 1 {
+		t.Errorf("Should have sent receipt once, not %d", attempts)
+	}
+}
+
+func TestVerifyHTTPClientErrorRedactsSecret(t *testing.T) {
+	secret := "0123456789abcdef"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Straddle the limit so truncating first would leave part of the secret behind
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(strings.Repeat("x", maxHTTPErrorBody-len(secret)/2) + secret))
+	}))
+	defer srv.Close()
+
+	c := New(secret)
+	c.ProductionURL = srv.URL
+
+	_, err := c.Verify("receipt123")
+	httpErr, ok := err.(HTTPError)
+	if !ok {
+		t.Fatalf("Should fail with an HTTPError, not %v", err)
+	}
+	padding := strings.Repeat("x", maxHTTPErrorBody-len(secret)/2)
+	if want := (padding + "[redacted]")[:maxHTTPErrorBody]; httpErr.Body != want {
+		t.Errorf("Should redact the secret before truncating, not %q", strings.TrimLeft(httpErr.Body, "x"))
+	}
+}
+
+func TestVerifyRedactsSecretRotatedMidRequest(t *testing.T) {
+	c := New("old-secret")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Rotate the secret while the request that carries the old one is in flight
+		c.SetSharedSecret("new-secret")
+		body, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(body)
+	}))
+	defer srv.Close()
+	c.ProductionURL = srv.URL
+
+	_, err := c.Verify("receipt123")
+	httpErr, ok := err.(HTTPError)
+	if !ok {
+		t.Fatalf("Should fail with an HTTPError, not %v", err)
+	}
+	if strings.Contains(httpErr.Body, "old-secret") || !strings.Contains(httpErr.Body, "[redacted]") {
+		t.Errorf("Should redact the secret that was sent, not %q", httpErr.Body)
+	}
+}
+
+func TestVerifyWithHeader(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Header = http.Header{}
+	c.Header.Set("Proxy-Authorization", "Bearer token123")
+	c.Header.Set("X-Trace-Id", "trace123")
+	c.Header.Set("Content-Type", "text/plain")
+
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Get("Proxy-Authorization") != "Bearer token123" || header.Get("X-Trace-Id") != "trace123" {
+		t.Errorf("Should send custom headers, not %v", header)
+	}
+	if header.Get("Content-Type") != "application/json" {
+		t.Errorf("Should keep JSON content type, not %q", header.Get("Content-Type"))
+	}
+}
+
+func TestVerifyWithUserAgent(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	var userAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "superscribe/1.x" {
+		t.Errorf("Should identify the package by default, not %q", userAgent)
+	}
+
+	c.UserAgent = "example-server/2.0"
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "example-server/2.0" {
+		t.Errorf("Should send custom user agent, not %q", userAgent)
+	}
+}
+
+func TestVerifyOnSuspiciousReceipt(t *testing.T) {
+	for _, test := range []struct {
+		body       string
+		suspicious bool
+	}{
+		{`{"status":21002}`, true},
+		{`{"status":21003}`, true},
+		{`{"status":21004}`, false},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(test.body))
+		}))
+
+		var status int
+		var receipt string
+		c := New("password")
+		c.ProductionURL = srv.URL
+		c.OnSuspiciousReceipt = func(s int, r string) {
+			status, receipt = s, r
+		}
+
+		if _, err := c.Verify("receipt123"); err == nil {
+			t.Errorf("Should have failed with %s", test.body)
+		}
+		if test.suspicious && (status == 0 || receipt != "receipt123") {
+			t.Errorf("Should report suspicious receipt for %s", test.body)
+		}
+		if !test.suspicious && status != 0 {
+			t.Errorf("Should not report receipt for %s", test.body)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestVerifyIncludeOldTransactions(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response8.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	var req map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Fatal(err)
+	}
+	if req["exclude-old-transactions"] != "true" {
+		t.Errorf("Should exclude old transactions by default, not %q", req["exclude-old-transactions"])
+	}
+
+	c.IncludeOldTransactions = true
+	result, err := c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req["exclude-old-transactions"] != "false" {
+		t.Errorf("Should include old transactions, not %q", req["exclude-old-transactions"])
+	}
+	if len(result.AllTransactions()) != 4 {
+		t.Errorf("Should keep every transaction, not %d", len(result.AllTransactions()))
+	}
+}
+
+func TestVerifyKeepRawResponse(t *testing.T) {
+	srv := newTestServer(t, "response1.json")
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	result, err := c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RawResponse != nil {
+		t.Error("Should not keep the response by default")
+	}
+
+	c.KeepRawResponse = true
+	result, err = c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile("testdata/response1.json"); string(result.RawResponse) != string(data) {
+		t.Errorf("Should keep the response, not %s", result.RawResponse)
+	}
+}
+
+func TestVerifyKeepRawResponseWithError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":0,"latest_receipt_info":"unexpected"}`))
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.KeepRawResponse = true
+
+	result, err := c.Verify("receipt123")
+	if err == nil {
+		t.Fatal("Should fail to parse the response")
+	}
+	if !strings.Contains(string(result.RawResponse), "unexpected") {
+		t.Errorf("Should return the response that failed to parse, not %s", result.RawResponse)
+	}
+}
+
+func TestRetryPolicyInternalDataAccessSchedule(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:                2,
+		BaseDelay:                  time.Second,
+		InternalDataAccessAttempts: 4,
+		InternalDataAccessDelay:    100 * time.Millisecond,
+	}
+
+	internal := StatusError{StatusInternalDataAccessFirst + 1}
+	if policy.attempts(internal) != 4 || policy.delay(2, internal) != 200*time.Millisecond {
+		t.Errorf("Should retry internal data access errors on their own schedule, not %d %s",
+			policy.attempts(internal), policy.delay(2, internal))
+	}
+	if policy.attempts(ErrUnreachable) != 2 || policy.delay(2, ErrUnreachable) != 2*time.Second {
+		t.Errorf("Should retry other errors on the base schedule, not %d %s",
+			policy.attempts(ErrUnreachable), policy.delay(2, ErrUnreachable))
+	}
+
+	policy.InternalDataAccessAttempts, policy.InternalDataAccessDelay = 0, 0
+	if policy.attempts(internal) != 2 || policy.delay(2, internal) != 2*time.Second {
+		t.Error("Should fall back to the base schedule when unset")
+	}
+}
+
+// statusTransport responds to every request with the status, or fails like a network outage
+type statusTransport struct {
+	attempts *int
+	body     string
+}
+
+func (st statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	*st.attempts++
+	if st.body == "" {
+		return nil, errors.New("network is unreachable")
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(st.body)),
+		Header:     http.Header{},
+		Request:    req,
+	}, nil
+}
+
+func TestVerifyRetriesEachErrorClass(t *testing.T) {
+	for _, test := range []struct {
+		body     string
+		attempts int
+	}{
+		{"", 2},
+		{`{"status":21005}`, 2},
+		{`{"status":21199}`, 4},
+		{`{"status":21004}`, 1},
+	} {
+		attempts := 0
+
+		c := New("password")
+		c.HTTPClient = &http.Client{Transport: statusTransport{&attempts, test.body}}
+		c.Retry = RetryPolicy{
+			MaxAttempts:                2,
+			BaseDelay:                  time.Millisecond,
+			InternalDataAccessAttempts: 4,
+			InternalDataAccessDelay:    time.Microsecond,
+		}
+
+		if _, err := c.Verify("receipt123"); err == nil {
+			t.Errorf("Should fail for %q", test.body)
+		}
+		if attempts != test.attempts {
+			t.Errorf("Should send receipt %d times for %q, not %d", test.attempts, test.body, attempts)
+		}
+	}
+}
+
+func TestVerifyMismatchedSecretHidesSecret(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	for _, handler := range []http.HandlerFunc{
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status":21004}`))
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			// A misbehaving proxy that echoes the request
+			w.WriteHeader(http.StatusInternalServerError)
+			io.Copy(w, r.Body)
+		},
+	} {
+		srv := httptest.NewServer(handler)
+
+		c := New("secret123")
+		c.ProductionURL = srv.URL
+		c.Retry.BaseDelay = time.Millisecond
+
+		_, err := c.Verify("receipt123")
+		if err == nil {
+			t.Fatal("Should have failed")
+		}
+		if strings.Contains(err.Error(), "secret123") || strings.Contains(logs.String(), "secret123") {
+			t.Errorf("Should keep the shared secret out of errors and logs: %v", err)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestVerifyBytes(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	var receiptData string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req VerifyReceiptRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		receiptData = req.ReceiptData
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	receipt, _ := newTestLocalReceipt(t)
+	encoded := base64.StdEncoding.EncodeToString(receipt)
+
+	for name, upload := range map[string][]byte{
+		"binary":  receipt,
+		"base64":  []byte(encoded),
+		"wrapped": []byte(encoded[:64] + "\r\n" + encoded[64:] + "\n"),
+	} {
+		if _, err := c.VerifyBytes(context.Background(), upload); err != nil {
+			t.Fatal(err)
+		}
+		if receiptData != encoded {
+			t.Errorf("Should send %s receipt as base64, not %.32s", name, receiptData)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response2.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	var receiptData string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req VerifyReceiptRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		receiptData = req.ReceiptData
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	prev, err := c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := c.Refresh(context.Background(), prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receiptData != "latestreceipt==" {
+		t.Errorf("Should verify the latest receipt, not %q", receiptData)
+	}
+	if result.TransactionID() != prev.TransactionID() {
+		t.Errorf("Should return the refreshed result, not %s", result.TransactionID())
+	}
+
+	receiptData = ""
+	prev.LatestReceipt = ""
+	if result, err := c.Refresh(context.Background(), prev); err != nil || result.TransactionID() != prev.TransactionID() {
+		t.Errorf("Should return the previous result without a latest receipt, not %v", err)
+	}
+	if receiptData != "" {
+		t.Errorf("Should not verify again without a latest receipt, not %q", receiptData)
+	}
+}
+
+func TestVerifySummary(t *testing.T) {
+	srv := newTestServer(t, "response5.json")
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Now = func() time.Time { return time.Date(2019, time.April, 15, 0, 0, 0, 0, time.UTC) }
+
+	var out bytes.Buffer
+	if err := c.VerifySummary(context.Background(), strings.NewReader("receipt123\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Product               month-premium
+Status                0
+Expires               2019-05-01T00:00:00Z (active)
+Environment           Production
+Original transaction  123456789012345
+Auto-renew            off
+`
+	if out.String() != expected {
+		t.Errorf("Should summarize the result as\n%s\nnot\n%s", expected, out.String())
+	}
+
+	if err := New("").VerifySummary(context.Background(), strings.NewReader("receipt123"), &out); err == nil {
+		t.Error("Should fail without a shared secret")
+	}
+}
+
+func TestWriteSummaryRefunded(t *testing.T) {
+	now := time.Date(2019, time.April, 15, 0, 0, 0, 0, time.UTC)
+	cancellationDate := newMillistamp(now.AddDate(0, 0, -1))
+	refunded := MockResult(ReceiptInfoBody{
+		ProductID:        "month-premium",
+		ExpiresDate:      newMillistamp(now.AddDate(0, 0, 7)),
+		CancellationDate: &cancellationDate,
+	})
+
+	var out bytes.Buffer
+	if err := WriteSummary(&out, refunded, now); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "2019-04-22T00:00:00Z (refunded)\n") {
+		t.Errorf("Should label the unexpired refund as refunded, not\n%s", out.String())
+	}
+}
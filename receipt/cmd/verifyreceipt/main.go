@@ -0,0 +1,23 @@
+// Command verifyreceipt verifies an App Store receipt read from stdin, either base64 text or the
+// binary file, and prints a summary. It reads the app's shared secret from the
+// APP_STORE_SHARED_SECRET environment variable.
+//
+//	APP_STORE_SHARED_SECRET=... verifyreceipt < receipt.txt
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/carpenterscode/superscribe/receipt"
+)
+
+func main() {
+	c := receipt.New(os.Getenv(receipt.SharedSecretEnv))
+
+	if err := c.VerifySummary(context.Background(), os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "verifyreceipt:", err)
+		os.Exit(1)
+	}
+}
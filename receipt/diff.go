@@ -0,0 +1,73 @@
+package receipt
+
+// FieldChange is a field that differs between two versions of a transaction, named after its
+// Transaction method
+type FieldChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// diffFields are the Transaction fields that matter for detecting a change in a subscription,
+// with times in UTC so that they compare equal however they were decoded
+var diffFields = []struct {
+	name  string
+	value func(Transaction) interface{}
+}{
+	{"ProductID", func(t Transaction) interface{} { return t.ProductID() }},
+	{"ExpiresAt", func(t Transaction) interface{} { return t.ExpiresAt().UTC() }},
+	{"IsTrialPeriod", func(t Transaction) interface{} { return t.IsTrialPeriod() }},
+	{"IsInIntroOfferPeriod", func(t Transaction) interface{} { return t.IsInIntroOfferPeriod() }},
+	{"CancelledAt", func(t Transaction) interface{} { return t.CancelledAt().UTC() }},
+	{"CancellationReason", func(t Transaction) interface{} {
+		if reason, ok := t.CancellationReason(); ok {
+			return reason
+		}
+		return nil
+	}},
+}
+
+// Diff lists the changes in product, expiry, trial and cancellation from a to b, such as between
+// a stored transaction and a freshly verified one. Either can be nil, whose fields are all nil, as
+// can a VerifyResult without Info.
+func Diff(a, b Transaction) []FieldChange {
+	a, b = diffable(a), diffable(b)
+
+	var changes []FieldChange
+	for _, field := range diffFields {
+		var oldValue, newValue interface{}
+		if a != nil {
+			oldValue = field.value(a)
+		}
+		if b != nil {
+			newValue = field.value(b)
+		}
+
+		if oldValue != newValue {
+			changes = append(changes, FieldChange{Field: field.name, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
+
+// diffable is the Info of a VerifyResult, which is nil rather than panicking when there's none
+func diffable(t Transaction) Transaction {
+	switch result := t.(type) {
+	case VerifyResult:
+		if result.Info == nil {
+			return nil
+		}
+		return result.Info
+	case *VerifyResult:
+		if result == nil || result.Info == nil {
+			return nil
+		}
+		return result.Info
+	}
+	return t
+}
+
+// Equal reports whether a and b agree on product, expiry, trial and cancellation
+func Equal(a, b Transaction) bool {
+	return len(Diff(a, b)) == 0
+}
@@ -0,0 +1,63 @@
+package receipt
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// Endpoints locates the verifyReceipt and App Store Server API endpoints in each environment
+type Endpoints struct {
+	ProductionURL    string
+	SandboxURL       string
+	APIProductionURL string
+	APISandboxURL    string
+}
+
+// DefaultEndpoints are Apple's global endpoints, which New uses. Apple serves every storefront
+// from them, China mainland included, so apps operating in China should use them too; there's no
+// separate regional preset. Use custom Endpoints only to go through a proxy or a local mock.
+var DefaultEndpoints = Endpoints{
+	ProductionURL:    productionURL,
+	SandboxURL:       sandboxURL,
+	APIProductionURL: apiProductionURL,
+	APISandboxURL:    apiSandboxURL,
+}
+
+// SetEndpoints points the Client at other endpoints, after checking that each uses HTTPS
+func (c *Client) SetEndpoints(e Endpoints) error {
+	for _, endpoint := range []string{e.ProductionURL, e.SandboxURL, e.APIProductionURL, e.APISandboxURL} {
+		if err := checkEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+
+	c.ProductionURL, c.SandboxURL = e.ProductionURL, e.SandboxURL
+	c.APIProductionURL, c.APISandboxURL = e.APIProductionURL, e.APISandboxURL
+	return nil
+}
+
+// checkEndpoint rejects plaintext HTTP, which would expose the shared secret and let a network
+// attacker forge App Store responses, except for a mock on the loopback interface
+func checkEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme == "https" && u.Host != "" {
+		return nil
+	}
+	if u.Scheme == "http" && isLoopback(u.Hostname()) {
+		return nil
+	}
+	return fmt.Errorf("Endpoint should use HTTPS, not %q", endpoint)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
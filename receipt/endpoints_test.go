@@ -0,0 +1,72 @@
+package receipt
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSetEndpoints(t *testing.T) {
+	c := New("password")
+	if c.ProductionURL != DefaultEndpoints.ProductionURL || c.APISandboxURL != DefaultEndpoints.APISandboxURL {
+		t.Errorf("Should use the default endpoints, not %s %s", c.ProductionURL, c.APISandboxURL)
+	}
+
+	proxy := Endpoints{
+		ProductionURL:    "https://proxy.example.com/buy/verifyReceipt",
+		SandboxURL:       "https://proxy.example.com/sandbox/verifyReceipt",
+		APIProductionURL: "https://proxy.example.com/api",
+		APISandboxURL:    "https://proxy.example.com/api-sandbox",
+	}
+	if err := c.SetEndpoints(proxy); err != nil {
+		t.Fatal(err)
+	}
+	if c.SandboxURL != proxy.SandboxURL || c.APIProductionURL != proxy.APIProductionURL {
+		t.Errorf("Should use the proxy's endpoints, not %s %s", c.SandboxURL, c.APIProductionURL)
+	}
+
+	downgraded := proxy
+	downgraded.SandboxURL = "http://proxy.example.com/sandbox/verifyReceipt"
+	if err := c.SetEndpoints(downgraded); err == nil {
+		t.Error("Should reject a plaintext HTTP endpoint")
+	}
+	if c.SandboxURL != proxy.SandboxURL {
+		t.Errorf("Should keep the endpoints after rejecting others, not %s", c.SandboxURL)
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	for _, test := range []struct {
+		endpoint string
+		ok       bool
+	}{
+		{"https://buy.itunes.apple.com/verifyReceipt", true},
+		{"http://127.0.0.1:8080/verifyReceipt", true},
+		{"http://[::1]:8080/verifyReceipt", true},
+		{"http://localhost/verifyReceipt", true},
+		{"http://buy.itunes.apple.com/verifyReceipt", false},
+		{"ftp://buy.itunes.apple.com/verifyReceipt", false},
+		{"https:///verifyReceipt", false},
+		{"", false},
+	} {
+		if err := checkEndpoint(test.endpoint); (err == nil) != test.ok {
+			t.Errorf("Should check %q, not %v", test.endpoint, err)
+		}
+	}
+}
+
+func TestVerifyRejectsPlaintextEndpoint(t *testing.T) {
+	c := New("password")
+	c.SandboxURL = "http://sandbox.itunes.apple.com/verifyReceipt"
+
+	if _, err := c.Verify("receipt123"); err == nil {
+		t.Error("Should refuse to send the receipt over plaintext HTTP")
+	}
+
+	c.SandboxURL = DefaultEndpoints.SandboxURL
+	c.APIKey = newTestAPIKey(t)
+	c.APIProductionURL = "http://api.storekit.itunes.apple.com"
+
+	if _, err := c.GetTransactionInfo(context.Background(), "2000000000000002"); err == nil {
+		t.Error("Should refuse to call the App Store Server API over plaintext HTTP")
+	}
+}
@@ -0,0 +1,176 @@
+package receipt
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// appleRootPEM is Apple Root CA - G3, which anchors the x5c chain of every JWS the App Store signs
+// https://www.apple.com/certificateauthority/AppleRootCA-G3.cer
+const appleRootPEM = `
+-----BEGIN CERTIFICATE-----
+MIICQzCCAcmgAwIBAgIILcX8iNLFS5UwCgYIKoZIzj0EAwMwZzEbMBkGA1UEAwwS
+QXBwbGUgUm9vdCBDQSAtIEczMSYwJAYDVQQLDB1BcHBsZSBDZXJ0aWZpY2F0aW9u
+IEF1dGhvcml0eTETMBEGA1UECgwKQXBwbGUgSW5jLjELMAkGA1UEBhMCVVMwHhcN
+MTQwNDMwMTgxOTA2WhcNMzkwNDMwMTgxOTA2WjBnMRswGQYDVQQDDBJBcHBsZSBS
+b290IENBIC0gRzMxJjAkBgNVBAsMHUFwcGxlIENlcnRpZmljYXRpb24gQXV0aG9y
+aXR5MRMwEQYDVQQKDApBcHBsZSBJbmMuMQswCQYDVQQGEwJVUzB2MBAGByqGSM49
+AgEGBSuBBAAiA2IABJjpLz1AcqTtkyJygRMc3RCV8cWjTnHcFBbZDuWmBSp3ZHtf
+TjjTuxxEtX/1H7YyYl3J6YRbTzBPEVoA/VhYDKX1DyxNB0cTddqXl5dvMVztK517
+IDvYuVTZXpmkOlEKMaNCMEAwHQYDVR0OBBYEFLuw3qFYM4iapIqZ3r6966/ayySr
+MA8GA1UdEwEB/wQFMAMBAf8wDgYDVR0PAQH/BAQDAgEGMAoGCCqGSM49BAMDA2gA
+MGUCMQCD6cHEFl4aXTQY2e3v9GwOAEZLuN+yRhHFD/3meoyhpmvOwgPUnPWTxnS4
+at+qIxUCMG1mihDK1A3UT82NQz60imOlM27jbdoXt2QfyFMm+YhidDkLF1vLUagM
+6BgD56KyKA==
+-----END CERTIFICATE-----
+`
+
+var errMalformedJWS = errors.New("Signed payload should be a JWS with header, payload and signature")
+
+var (
+	// oidAppleSigning marks the certificates the App Store signs receipts and transactions with
+	oidAppleSigning = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+
+	// oidAppleWWDR marks the Apple Worldwide Developer Relations intermediates that issue them
+	oidAppleWWDR = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+)
+
+type jwsHeader struct {
+	Alg string   `json:"alg"`
+	X5c []string `json:"x5c"`
+}
+
+func appleRoots() *x509.CertPool {
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(appleRootPEM))
+	return roots
+}
+
+// roots is the Client's RootCertificates, or the Apple root CA when nil
+func (c *Client) roots() *x509.CertPool {
+	if c.RootCertificates != nil {
+		return c.RootCertificates
+	}
+	return appleRoots()
+}
+
+// verifyJWS checks that the compact serialized JWS is signed with ES256 by a certificate chaining
+// up to one of the roots at the time now, then decodes its payload into v.
+func verifyJWS(signed string, roots *x509.CertPool, now time.Time, v interface{}) error {
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		return errMalformedJWS
+	}
+
+	headerData, headerErr := base64.RawURLEncoding.DecodeString(parts[0])
+	if headerErr != nil {
+		return errMalformedJWS
+	}
+
+	var header jwsHeader
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		return errMalformedJWS
+	}
+
+	if header.Alg != "ES256" {
+		return errors.New("Signed payload should use the ES256 algorithm, not " + header.Alg)
+	}
+
+	leaf, chainErr := verifyCertificateChain(header.X5c, roots, now)
+	if chainErr != nil {
+		return chainErr
+	}
+
+	publicKey, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("Signing certificate should have an ECDSA public key")
+	}
+
+	signature, sigErr := base64.RawURLEncoding.DecodeString(parts[2])
+	if sigErr != nil || len(signature) != 64 {
+		return errMalformedJWS
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(publicKey, digest[:], r, s) {
+		return errors.New("Signed payload signature should match its signing certificate")
+	}
+
+	payload, payloadErr := base64.RawURLEncoding.DecodeString(parts[1])
+	if payloadErr != nil {
+		return errMalformedJWS
+	}
+
+	return json.Unmarshal(payload, v)
+}
+
+// verifyCertificateChain checks the signature and validity period of every certificate in the
+// x5c header, which lists the leaf first, and returns the leaf.
+func verifyCertificateChain(x5c []string, roots *x509.CertPool, now time.Time) (*x509.Certificate, error) {
+	if len(x5c) == 0 {
+		return nil, errors.New("Signed payload should include its certificate chain")
+	}
+
+	certs := make([]*x509.Certificate, len(x5c))
+	for i, encoded := range x5c {
+		der, decodeErr := base64.StdEncoding.DecodeString(encoded)
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		cert, parseErr := x509.ParseCertificate(der)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	chains, verifyErr := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if verifyErr != nil {
+		return nil, verifyErr
+	}
+	if err := checkAppleMarkers(chains); err != nil {
+		return nil, err
+	}
+
+	return certs[0], nil
+}
+
+// checkAppleMarkers requires one of the verified chains to carry Apple's signing and intermediate
+// markers, since certificates Apple issues to developers chain up to the same root
+func checkAppleMarkers(chains [][]*x509.Certificate) error {
+	for _, chain := range chains {
+		if len(chain) > 2 && hasExtension(chain[0], oidAppleSigning) && hasExtension(chain[1], oidAppleWWDR) {
+			return nil
+		}
+	}
+	return errors.New("Signing certificate chain should have Apple's signing and intermediate markers")
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
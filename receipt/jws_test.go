@@ -0,0 +1,368 @@
+package receipt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigner signs JWS payloads with a leaf certificate chaining up to its own root
+type testSigner struct {
+	roots *x509.CertPool
+	key   *ecdsa.PrivateKey
+	x5c   []string
+}
+
+// appleMarker is the extension Apple puts on the certificates in its signing chains
+func appleMarker(oid asn1.ObjectIdentifier) pkix.Extension {
+	return pkix.Extension{Id: oid, Value: asn1.NullBytes}
+}
+
+func newTestCert(t *testing.T, name string, serial int64, isCA bool, notAfter time.Time,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+	extensions ...pkix.Extension) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtraExtensions:       extensions,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, certErr := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if certErr != nil {
+		t.Fatal(certErr)
+	}
+
+	cert, parseErr := x509.ParseCertificate(der)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	return cert, key
+}
+
+// newTestSignerMarked builds a chain like Apple's, leaving out the leaf or intermediate marker when
+// not asked for
+func newTestSignerMarked(t *testing.T, leafNotAfter time.Time, leafMarked, intermediateMarked bool) testSigner {
+	var leafExtensions, intermediateExtensions []pkix.Extension
+	if leafMarked {
+		leafExtensions = append(leafExtensions, appleMarker(oidAppleSigning))
+	}
+	if intermediateMarked {
+		intermediateExtensions = append(intermediateExtensions, appleMarker(oidAppleWWDR))
+	}
+
+	notAfter := time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC)
+	root, rootKey := newTestCert(t, "Test Root", 1, true, notAfter, nil, nil)
+	intermediate, intermediateKey := newTestCert(t, "Test Intermediate", 2, true, notAfter, root, rootKey,
+		intermediateExtensions...)
+	leaf, leafKey := newTestCert(t, "Test Leaf", 3, false, leafNotAfter, intermediate, intermediateKey,
+		leafExtensions...)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	return testSigner{
+		roots: roots,
+		key:   leafKey,
+		x5c: []string{
+			base64.StdEncoding.EncodeToString(leaf.Raw),
+			base64.StdEncoding.EncodeToString(intermediate.Raw),
+			base64.StdEncoding.EncodeToString(root.Raw),
+		},
+	}
+}
+
+func newTestSignerExpiring(t *testing.T, leafNotAfter time.Time) testSigner {
+	return newTestSignerMarked(t, leafNotAfter, true, true)
+}
+
+func newTestSigner(t *testing.T) testSigner {
+	return newTestSignerExpiring(t, time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func (s testSigner) sign(t *testing.T, payload interface{}) string {
+	header, headerErr := json.Marshal(jwsHeader{Alg: "ES256", X5c: s.x5c})
+	if headerErr != nil {
+		t.Fatal(headerErr)
+	}
+	body, bodyErr := json.Marshal(payload)
+	if bodyErr != nil {
+		t.Fatal(bodyErr)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(body)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, sig, signErr := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if signErr != nil {
+		t.Fatal(signErr)
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	sig.FillBytes(signature[32:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+var jwsTestTime = time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+func TestVerifyJWS(t *testing.T) {
+	signer := newTestSigner(t)
+	signed := signer.sign(t, map[string]string{"productId": "month-premium"})
+
+	var payload JWSTransactionBody
+	if err := verifyJWS(signed, signer.roots, jwsTestTime, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ProductID != "month-premium" {
+		t.Errorf("Should decode payload, not %v", payload)
+	}
+}
+
+func TestVerifyJWSRejectsUntrustedRoot(t *testing.T) {
+	signed := newTestSigner(t).sign(t, map[string]string{"productId": "month-premium"})
+
+	var payload JWSTransactionBody
+	if err := verifyJWS(signed, appleRoots(), jwsTestTime, &payload); err == nil {
+		t.Error("Should reject a chain that doesn't lead to the Apple root")
+	}
+}
+
+func TestVerifyJWSRejectsExpiredCertificate(t *testing.T) {
+	signer := newTestSignerExpiring(t, time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC))
+	signed := signer.sign(t, map[string]string{"productId": "month-premium"})
+
+	var payload JWSTransactionBody
+	if err := verifyJWS(signed, signer.roots, jwsTestTime, &payload); err == nil {
+		t.Error("Should reject an expired signing certificate")
+	}
+}
+
+func TestVerifyJWSRejectsUnmarkedChain(t *testing.T) {
+	notAfter := time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name                           string
+		leafMarked, intermediateMarked bool
+	}{
+		{"leaf", false, true},
+		{"intermediate", true, false},
+		{"leaf or intermediate", false, false},
+	} {
+		signer := newTestSignerMarked(t, notAfter, test.leafMarked, test.intermediateMarked)
+		signed := signer.sign(t, map[string]string{"productId": "month-premium"})
+
+		var payload JWSTransactionBody
+		if err := verifyJWS(signed, signer.roots, jwsTestTime, &payload); err == nil {
+			t.Errorf("Should reject a chain without Apple's %s marker", test.name)
+		}
+	}
+}
+
+func TestVerifyJWSRejectsTamperedPayload(t *testing.T) {
+	signer := newTestSigner(t)
+	signed := signer.sign(t, map[string]string{"productId": "month-premium"})
+	forged := signer.sign(t, map[string]string{"productId": "year-premium"})
+
+	// Swap in the payload from another JWS while keeping the original signature
+	parts := strings.Split(signed, ".")
+	tampered := parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+
+	var payload JWSTransactionBody
+	if err := verifyJWS(tampered, signer.roots, jwsTestTime, &payload); err == nil {
+		t.Error("Should reject a payload that doesn't match the signature")
+	}
+}
+
+func TestVerifyJWSRejectsMalformed(t *testing.T) {
+	var payload JWSTransactionBody
+	for _, signed := range []string{"", "a.b", "a.b.c", "!!!.b.c"} {
+		if err := verifyJWS(signed, appleRoots(), jwsTestTime, &payload); err == nil {
+			t.Errorf("Should reject malformed JWS %q", signed)
+		}
+	}
+}
+
+func TestVerifyTransactionRequiresAppleRoot(t *testing.T) {
+	signed := newTestSigner(t).sign(t, map[string]string{"productId": "month-premium"})
+	if _, err := New("password").VerifyTransaction(signed); err == nil {
+		t.Error("Should reject a transaction not signed by Apple")
+	}
+}
+
+func TestVerifyTransactionWithRootCertificates(t *testing.T) {
+	signer := newTestSigner(t)
+	signed := signer.sign(t, map[string]string{"transactionId": "2000000000000002"})
+
+	c := New("password")
+	c.RootCertificates = signer.roots
+	c.Now = func() time.Time { return jwsTestTime }
+
+	transaction, err := c.VerifyTransaction(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.TransactionID() != "2000000000000002" {
+		t.Errorf("Should decode transaction signed by a custom root, not %s", transaction.TransactionID())
+	}
+
+	c.RootCertificates = appleRoots()
+	if _, err := c.VerifyTransaction(signed); err == nil {
+		t.Error("Should reject a transaction not signed by the custom root")
+	}
+}
+
+func TestSignedTransactionInfo(t *testing.T) {
+	var body JWSTransactionBody
+	data := []byte(`{
+		"transactionId": "2000000000000002",
+		"originalTransactionId": "2000000000000001",
+		"productId": "month-premium",
+		"purchaseDate": 1622505600000,
+		"originalPurchaseDate": 1619827200000,
+		"expiresDate": 1625097600000,
+		"quantity": 1,
+		"offerType": 1,
+		"offerDiscountType": "FREE_TRIAL"
+	}`)
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	var info Info = SignedTransaction{body}
+
+	expiresAt := time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC)
+	if !info.ExpiresAt().Equal(expiresAt) {
+		t.Errorf("Should parse %s as %s", info.ExpiresAt(), expiresAt)
+	}
+	if info.TransactionID() != "2000000000000002" || info.OriginalTransactionID() != "2000000000000001" {
+		t.Error("Should parse transaction IDs")
+	}
+	if !info.IsTrialPeriod() || !info.IsInIntroOfferPeriod() {
+		t.Error("Should parse free trial introductory offer")
+	}
+	if !info.CancelledAt().IsZero() {
+		t.Error("Should not be revoked")
+	}
+	if info.Quantity() != 1 || (SignedTransaction{}).Quantity() != 1 {
+		t.Errorf("Should default quantity to 1, not %d", (SignedTransaction{}).Quantity())
+	}
+}
+
+func TestSignedTransactionOffer(t *testing.T) {
+	for _, c := range []struct {
+		json       string
+		offerType  int
+		identifier string
+	}{
+		{`{}`, 0, ""},
+		{`{"offerType":1}`, OfferTypeIntroductory, ""},
+		{`{"offerType":2,"offerIdentifier":"spring-promo"}`, OfferTypePromotional, "spring-promo"},
+		{`{"offerType":3,"offerIdentifier":"winback"}`, OfferTypeCode, "winback"},
+	} {
+		var body JWSTransactionBody
+		if err := json.Unmarshal([]byte(c.json), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		transaction := SignedTransaction{body}
+		if transaction.OfferType() != c.offerType || transaction.OfferIdentifier() != c.identifier {
+			t.Errorf("%s should have offer %d %q, not %d %q", c.json, c.offerType, c.identifier,
+				transaction.OfferType(), transaction.OfferIdentifier())
+		}
+	}
+}
+
+func TestSignedTransactionRevoked(t *testing.T) {
+	var body JWSTransactionBody
+	data := []byte(`{
+		"transactionId": "2000000000000002",
+		"expiresDate": 1625097600000,
+		"revocationDate": 1623715200000,
+		"revocationReason": 1
+	}`)
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	transaction := SignedTransaction{body}
+	revokedAt, revoked := transaction.RevokedAt()
+	if !revoked || !revokedAt.Equal(time.Date(2021, time.June, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Should parse revocation date, not %s", revokedAt)
+	}
+	if transaction.RevocationReason() != 1 {
+		t.Errorf("Should parse revocation reason, not %d", transaction.RevocationReason())
+	}
+	if transaction.IsActive(jwsTestTime) {
+		t.Error("Should not be active after revocation")
+	}
+
+	if _, revoked := (SignedTransaction{}).RevokedAt(); revoked {
+		t.Error("Should not be revoked without a revocation date")
+	}
+	if !(SignedTransaction{JWSTransactionBody{ExpiresDate: body.ExpiresDate}}).IsActive(jwsTestTime) {
+		t.Error("Should be active before expiring")
+	}
+}
+
+func TestSignedTransactionOwnership(t *testing.T) {
+	for _, c := range []struct {
+		json      string
+		ownership string
+		shared    bool
+	}{
+		{`{}`, OwnershipPurchased, false},
+		{`{"inAppOwnershipType":"PURCHASED"}`, OwnershipPurchased, false},
+		{`{"inAppOwnershipType":"FAMILY_SHARED"}`, OwnershipFamilyShared, true},
+	} {
+		var body JWSTransactionBody
+		if err := json.Unmarshal([]byte(c.json), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		transaction := SignedTransaction{body}
+		if transaction.OwnershipType() != c.ownership || transaction.IsFamilyShared() != c.shared {
+			t.Errorf("%s should be owned as %s, not %s", c.json, c.ownership, transaction.OwnershipType())
+		}
+	}
+}
+
+func TestSignedTransactionAppAccountToken(t *testing.T) {
+	for token, expected := range map[string]string{
+		"":                                     "",
+		"7e3fb20b-4cdb-47cc-936d-99d65f608138": "7e3fb20b-4cdb-47cc-936d-99d65f608138",
+		"7E3FB20B-4CDB-47CC-936D-99D65F608138": "7E3FB20B-4CDB-47CC-936D-99D65F608138",
+		"7e3fb20b4cdb47cc936d99d65f608138":     "",
+		"7e3fb20b-4cdb-47cc-936d-99d65f60813g": "",
+		"user123":                              "",
+	} {
+		transaction := SignedTransaction{JWSTransactionBody{AppAccountToken: token}}
+		if actual := transaction.AppAccountToken(); actual != expected {
+			t.Errorf("Should read app account token %q as %q, not %q", token, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package receipt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha1" // Register digest algorithms for crypto.Hash.New
+	_ "crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// These structs model the PKCS #7 container of a local receipt
+// https://tools.ietf.org/html/rfc2315
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     pkcs7RawCertificates `asn1:"optional,tag:0"`
+	CRLs             []asn1.RawValue      `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo    `asn1:"set"`
+}
+
+type pkcs7RawCertificates struct {
+	Raw asn1.RawContent
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   []pkcs7Attribute `asn1:"optional,omitempty,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes []pkcs7Attribute `asn1:"optional,omitempty,tag:1"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	IssuerName   asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+// receiptAttribute is a field of the receipt payload or of an in-app purchase receipt
+// https://developer.apple.com/library/archive/releasenotes/General/ValidateAppStoreReceipt/Chapters/ReceiptFields.html
+type receiptAttribute struct {
+	Type    int
+	Version int
+	Value   []byte
+}
+
+// Receipt and in-app purchase receipt field types
+const (
+	attrReceiptType                = 0
+	attrBundleID                   = 2
+	attrApplicationVersion         = 3
+	attrCreationDate               = 12
+	attrInApp                      = 17
+	attrOriginalApplicationVersion = 19
+
+	attrQuantity              = 1701
+	attrProductID             = 1702
+	attrTransactionID         = 1703
+	attrPurchaseDate          = 1704
+	attrOriginalTransactionID = 1705
+	attrOriginalPurchaseDate  = 1706
+	attrExpiresDate           = 1708
+	attrCancellationDate      = 1712
+	attrWebOrderLineItemID    = 1711
+	attrIsInIntroOfferPeriod  = 1719
+)
+
+var errMalformedLocalReceipt = errors.New("Receipt data should be a PKCS #7 signed App Store receipt")
+
+// LocalReceipt is an app receipt decoded on the server without asking the App Store
+type LocalReceipt struct {
+	Receipt
+
+	// Environment is either EnvironmentProduction or EnvironmentSandbox
+	Environment string
+
+	transactions []Transaction
+}
+
+// AllTransactions lists the in-app purchases in chronological order
+func (r LocalReceipt) AllTransactions() []Transaction {
+	transactions := make([]Transaction, len(r.transactions))
+	copy(transactions, r.transactions)
+	return transactions
+}
+
+// ParseReceipt decodes the base64 encoded app receipt without checking its signature, so the
+// receipt can't be trusted. It only suits a fast pre-filter, such as on the bundle ID, before
+// verifying the receipt with the App Store or VerifyLocalReceipt.
+func ParseReceipt(base64Data string) (LocalReceipt, error) {
+	data, decodeErr := base64.StdEncoding.DecodeString(base64Data)
+	if decodeErr != nil {
+		return LocalReceipt{}, decodeErr
+	}
+
+	_, payload, err := decodeSignedData(data)
+	if err != nil {
+		return LocalReceipt{}, err
+	}
+	return parseLocalReceipt(payload)
+}
+
+// VerifyLocalReceipt checks the PKCS #7 signature of the receipt stored in the app bundle and its
+// certificate chain up to rootCert, in DER or PEM form, then decodes the app and its in-app
+// purchases without contacting the App Store. The chain needs Apple's receipt signing and
+// intermediate certificate markers, and is checked as of the receipt's creation date, since
+// Apple's intermediate certificates expire long before old receipts stop mattering.
+func VerifyLocalReceipt(receiptData []byte, rootCert []byte) (VerifyResult, error) {
+	if block, _ := pem.Decode(rootCert); block != nil {
+		rootCert = block.Bytes
+	}
+	root, rootErr := x509.ParseCertificate(rootCert)
+	if rootErr != nil {
+		return VerifyResult{}, rootErr
+	}
+
+	signedData, payload, err := decodeSignedData(receiptData)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	local, parseErr := parseLocalReceipt(payload)
+	if parseErr != nil {
+		return VerifyResult{}, parseErr
+	}
+
+	var rawCerts asn1.RawValue
+	if _, err := asn1.Unmarshal(signedData.Certificates.Raw, &rawCerts); err != nil {
+		return VerifyResult{}, errMalformedLocalReceipt
+	}
+	certs, certsErr := x509.ParseCertificates(rawCerts.Bytes)
+	if certsErr != nil {
+		return VerifyResult{}, certsErr
+	}
+
+	if err := verifySignerInfo(signedData.SignerInfos[0], certs, root, local.CreatedAt(), payload); err != nil {
+		return VerifyResult{}, err
+	}
+
+	var v validation
+	v.response.appReceipt = local.body
+	v.response.Environment = local.Environment
+	v.response.transactions = local.transactions
+	if len(local.transactions) > 0 {
+		v.response.info = local.transactions[len(local.transactions)-1]
+	} else {
+		v.response.info = modernReceiptInfo{}
+	}
+	return v.result(), nil
+}
+
+// isSignedData reports whether the data is a PKCS #7 container, like a receipt read from the app
+// bundle, rather than base64 text
+func isSignedData(data []byte) bool {
+	var contentInfo pkcs7ContentInfo
+	_, err := asn1.Unmarshal(data, &contentInfo)
+	return err == nil && contentInfo.ContentType.Equal(oidSignedData)
+}
+
+// decodeSignedData unwraps the PKCS #7 container to find the receipt payload
+func decodeSignedData(data []byte) (pkcs7SignedData, []byte, error) {
+	var contentInfo pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(data, &contentInfo); err != nil {
+		return pkcs7SignedData{}, nil, errMalformedLocalReceipt
+	}
+	if !contentInfo.ContentType.Equal(oidSignedData) {
+		return pkcs7SignedData{}, nil, errMalformedLocalReceipt
+	}
+
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return pkcs7SignedData{}, nil, errMalformedLocalReceipt
+	}
+	if !signedData.ContentInfo.ContentType.Equal(oidData) || len(signedData.SignerInfos) != 1 {
+		return pkcs7SignedData{}, nil, errMalformedLocalReceipt
+	}
+
+	var payload []byte
+	if _, err := asn1.Unmarshal(signedData.ContentInfo.Content.Bytes, &payload); err != nil {
+		return pkcs7SignedData{}, nil, errMalformedLocalReceipt
+	}
+	return signedData, payload, nil
+}
+
+func parseLocalReceipt(payload []byte) (LocalReceipt, error) {
+	var attrs []receiptAttribute
+	if _, err := asn1.UnmarshalWithParams(payload, &attrs, "set"); err != nil {
+		return LocalReceipt{}, errMalformedLocalReceipt
+	}
+
+	var local LocalReceipt
+	var infoList []ReceiptInfoBody
+	for _, attr := range attrs {
+		switch attr.Type {
+		case attrReceiptType:
+			local.body.ReceiptType = parseReceiptString(attr.Value)
+		case attrBundleID:
+			local.body.BundleID = parseReceiptString(attr.Value)
+		case attrApplicationVersion:
+			local.body.ApplicationVersion = parseReceiptString(attr.Value)
+		case attrOriginalApplicationVersion:
+			local.body.OriginalApplicationVersion = parseReceiptString(attr.Value)
+		case attrCreationDate:
+			local.body.ReceiptCreationDate = parseReceiptDate(attr.Value)
+		case attrInApp:
+			body, err := parseInAppReceipt(attr.Value)
+			if err != nil {
+				return LocalReceipt{}, err
+			}
+			infoList = append(infoList, body)
+		}
+	}
+
+	local.body.InApp = infoList
+	local.transactions = newTransactions(infoList)
+	if local.body.ReceiptType == "ProductionSandbox" {
+		local.Environment = EnvironmentSandbox
+	} else {
+		local.Environment = EnvironmentProduction
+	}
+	return local, nil
+}
+
+// verifySignerInfo checks that the signer's certificate chains up to root through certificates
+// marked as Apple's, and that it signed the payload, either directly or through a message digest
+// among the authenticated attributes.
+func verifySignerInfo(signer pkcs7SignerInfo, certs []*x509.Certificate, root *x509.Certificate,
+	now time.Time, payload []byte) error {
+
+	var leaf *x509.Certificate
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs {
+		if cert.SerialNumber.Cmp(signer.IssuerAndSerialNumber.SerialNumber) == 0 &&
+			bytes.Equal(cert.RawIssuer, signer.IssuerAndSerialNumber.IssuerName.FullBytes) {
+			leaf = cert
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	if leaf == nil {
+		return errors.New("Receipt should include the certificate that signed it")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	chains, verifyErr := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if verifyErr != nil {
+		return verifyErr
+	}
+	if err := checkAppleMarkers(chains); err != nil {
+		return err
+	}
+
+	var hash crypto.Hash
+	switch {
+	case signer.DigestAlgorithm.Algorithm.Equal(oidSHA1):
+		hash = crypto.SHA1
+	case signer.DigestAlgorithm.Algorithm.Equal(oidSHA256):
+		hash = crypto.SHA256
+	default:
+		return errors.New("Receipt should be signed with a SHA-1 or SHA-256 digest")
+	}
+
+	h := hash.New()
+	h.Write(payload)
+	digest := h.Sum(nil)
+
+	if len(signer.AuthenticatedAttributes) > 0 {
+		var messageDigest []byte
+		for _, attr := range signer.AuthenticatedAttributes {
+			if attr.Type.Equal(oidMessageDigest) {
+				if _, err := asn1.Unmarshal(attr.Value.Bytes, &messageDigest); err != nil {
+					return errMalformedLocalReceipt
+				}
+			}
+		}
+		if !bytes.Equal(messageDigest, digest) {
+			return errors.New("Receipt payload should match its signed message digest")
+		}
+
+		// The signature covers the DER encoding of the attributes as a SET
+		signed, marshalErr := asn1.MarshalWithParams(signer.AuthenticatedAttributes, "set")
+		if marshalErr != nil {
+			return marshalErr
+		}
+		h = hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	publicKey, ok := leaf.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("Signing certificate should have an RSA public key")
+	}
+	if err := rsa.VerifyPKCS1v15(publicKey, hash, digest, signer.EncryptedDigest); err != nil {
+		return errors.New("Receipt signature should match its signing certificate")
+	}
+	return nil
+}
+
+func parseInAppReceipt(data []byte) (ReceiptInfoBody, error) {
+	var attrs []receiptAttribute
+	if _, err := asn1.UnmarshalWithParams(data, &attrs, "set"); err != nil {
+		return ReceiptInfoBody{}, errMalformedLocalReceipt
+	}
+
+	var body ReceiptInfoBody
+	for _, attr := range attrs {
+		switch attr.Type {
+		case attrQuantity:
+			body.Quantity = strconv.Itoa(parseReceiptInt(attr.Value))
+		case attrProductID:
+			body.ProductID = parseReceiptString(attr.Value)
+		case attrTransactionID:
+			body.TransactionID = parseReceiptString(attr.Value)
+		case attrOriginalTransactionID:
+			body.OriginalTransactionID = parseReceiptString(attr.Value)
+		case attrPurchaseDate:
+			body.PurchaseDate = parseReceiptDate(attr.Value)
+		case attrOriginalPurchaseDate:
+			body.OriginalPurchaseDate = parseReceiptDate(attr.Value)
+		case attrExpiresDate:
+			body.ExpiresDate = parseReceiptDate(attr.Value)
+		case attrCancellationDate:
+			if cancellationDate := parseReceiptDate(attr.Value); cancellationDate != 0 {
+				body.CancellationDate = &cancellationDate
+			}
+		case attrWebOrderLineItemID:
+			if id := parseReceiptInt(attr.Value); id != 0 {
+				body.WebOrderLineItemID = strconv.Itoa(id)
+			}
+		case attrIsInIntroOfferPeriod:
+			body.IsInIntroOfferPeriod = parseReceiptInt(attr.Value) == 1
+		}
+	}
+	return body, nil
+}
+
+// parseReceiptString decodes a UTF8String or IA5String field value, or returns empty
+func parseReceiptString(data []byte) string {
+	var s string
+	if _, err := asn1.Unmarshal(data, &s); err != nil {
+		return ""
+	}
+	return s
+}
+
+// parseReceiptInt decodes an INTEGER field value, or returns 0
+func parseReceiptInt(data []byte) int {
+	var i int
+	if _, err := asn1.Unmarshal(data, &i); err != nil {
+		return 0
+	}
+	return i
+}
+
+// parseReceiptDate decodes an RFC 3339 date field value, or returns 0 when empty
+func parseReceiptDate(data []byte) Millistamp {
+	t, err := time.Parse(time.RFC3339, parseReceiptString(data))
+	if err != nil {
+		return 0
+	}
+	return newMillistamp(t)
+}
@@ -0,0 +1,333 @@
+package receipt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestRSACert(t *testing.T, name string, serial int64, isCA bool, parent *x509.Certificate,
+	parentKey *rsa.PrivateKey, extensions ...pkix.Extension) (*x509.Certificate, *rsa.PrivateKey) {
+
+	key, keyErr := rsa.GenerateKey(rand.Reader, 2048)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtraExtensions:       extensions,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, certErr := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if certErr != nil {
+		t.Fatal(certErr)
+	}
+
+	cert, parseErr := x509.ParseCertificate(der)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	return cert, key
+}
+
+func marshalReceiptAttribute(t *testing.T, attrType int, value interface{}, params string) receiptAttribute {
+	data, err := asn1.MarshalWithParams(value, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return receiptAttribute{Type: attrType, Version: 1, Value: data}
+}
+
+func marshalReceiptAttributes(t *testing.T, attrs ...receiptAttribute) []byte {
+	data, err := asn1.MarshalWithParams(attrs, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func marshalSignerAttribute(t *testing.T, oid asn1.ObjectIdentifier, value interface{}) pkcs7Attribute {
+	data, err := asn1.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkcs7Attribute{
+		Type:  oid,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: data},
+	}
+}
+
+// signLocalReceipt wraps the payload in PKCS #7 signed data the way Apple does, signing either the
+// payload itself or authenticated attributes holding its digest
+func signLocalReceipt(t *testing.T, payload []byte, leaf *x509.Certificate, key *rsa.PrivateKey,
+	signedAttributes bool, certs ...*x509.Certificate) []byte {
+
+	signed := payload
+	var attrs []pkcs7Attribute
+	if signedAttributes {
+		payloadDigest := sha256.Sum256(payload)
+		attrs = []pkcs7Attribute{
+			marshalSignerAttribute(t, asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}, oidData),
+			marshalSignerAttribute(t, oidMessageDigest, payloadDigest[:]),
+		}
+
+		var err error
+		if signed, err = asn1.MarshalWithParams(attrs, "set"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digest := sha256.Sum256(signed)
+	signature, signErr := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if signErr != nil {
+		t.Fatal(signErr)
+	}
+
+	octets, octetsErr := asn1.Marshal(payload)
+	if octetsErr != nil {
+		t.Fatal(octetsErr)
+	}
+
+	var rawCerts []byte
+	for _, cert := range certs {
+		rawCerts = append(rawCerts, cert.Raw...)
+	}
+
+	sha256Algorithm := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	signedData, signedErr := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+		ContentInfo: pkcs7ContentInfo{
+			ContentType: oidData,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets},
+		},
+		Certificates: pkcs7RawCertificates{
+			Raw: append([]byte{0xa0, 0x82, byte(len(rawCerts) >> 8), byte(len(rawCerts))}, rawCerts...),
+		},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: pkcs7IssuerAndSerial{
+				IssuerName:   asn1.RawValue{FullBytes: leaf.RawIssuer},
+				SerialNumber: leaf.SerialNumber,
+			},
+			DigestAlgorithm:         sha256Algorithm,
+			AuthenticatedAttributes: attrs,
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1},
+				Parameters: asn1.NullRawValue,
+			},
+			EncryptedDigest: signature,
+		}},
+	})
+	if signedErr != nil {
+		t.Fatal(signedErr)
+	}
+
+	data, dataErr := asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	if dataErr != nil {
+		t.Fatal(dataErr)
+	}
+	return data
+}
+
+// testReceiptOptions departs from how Apple signs receipts
+type testReceiptOptions struct {
+	unmarkedLeaf, unmarkedIntermediate bool
+	signedAttributes                   bool
+}
+
+func newTestLocalReceipt(t *testing.T) (data []byte, rootCert []byte) {
+	return newTestLocalReceiptWith(t, testReceiptOptions{})
+}
+
+func newTestLocalReceiptWith(t *testing.T, options testReceiptOptions) (data []byte, rootCert []byte) {
+	var leafExtensions, intermediateExtensions []pkix.Extension
+	if !options.unmarkedLeaf {
+		leafExtensions = append(leafExtensions, appleMarker(oidAppleSigning))
+	}
+	if !options.unmarkedIntermediate {
+		intermediateExtensions = append(intermediateExtensions, appleMarker(oidAppleWWDR))
+	}
+
+	root, rootKey := newTestRSACert(t, "Test Root", 1, true, nil, nil)
+	intermediate, intermediateKey := newTestRSACert(t, "Test Intermediate", 2, true, root, rootKey,
+		intermediateExtensions...)
+	leaf, leafKey := newTestRSACert(t, "Test Leaf", 3, false, intermediate, intermediateKey, leafExtensions...)
+
+	inApp := func(transactionID, purchaseDate, expiresDate string) receiptAttribute {
+		return receiptAttribute{Type: attrInApp, Version: 1, Value: marshalReceiptAttributes(t,
+			marshalReceiptAttribute(t, attrQuantity, 1, ""),
+			marshalReceiptAttribute(t, attrProductID, "month-premium", "utf8"),
+			marshalReceiptAttribute(t, attrTransactionID, transactionID, "utf8"),
+			marshalReceiptAttribute(t, attrOriginalTransactionID, "123456789012345", "utf8"),
+			marshalReceiptAttribute(t, attrPurchaseDate, purchaseDate, "ia5"),
+			marshalReceiptAttribute(t, attrOriginalPurchaseDate, "2021-03-01T00:00:00Z", "ia5"),
+			marshalReceiptAttribute(t, attrExpiresDate, expiresDate, "ia5"),
+			marshalReceiptAttribute(t, attrIsInIntroOfferPeriod, 0, ""),
+		)}
+	}
+
+	payload := marshalReceiptAttributes(t,
+		marshalReceiptAttribute(t, attrReceiptType, "ProductionSandbox", "utf8"),
+		marshalReceiptAttribute(t, attrBundleID, "com.example.superscribe", "utf8"),
+		marshalReceiptAttribute(t, attrApplicationVersion, "42", "utf8"),
+		marshalReceiptAttribute(t, attrOriginalApplicationVersion, "1", "utf8"),
+		marshalReceiptAttribute(t, attrCreationDate, "2021-05-15T00:00:00Z", "ia5"),
+		inApp("123456789012346", "2021-04-01T00:00:00Z", "2021-05-01T00:00:00Z"),
+		inApp("123456789012345", "2021-03-01T00:00:00Z", "2021-04-01T00:00:00Z"),
+	)
+
+	return signLocalReceipt(t, payload, leaf, leafKey, options.signedAttributes, leaf, intermediate), root.Raw
+}
+
+func TestVerifyLocalReceipt(t *testing.T) {
+	data, rootCert := newTestLocalReceipt(t)
+
+	result, err := VerifyLocalReceipt(data, rootCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Receipt.BundleID() != "com.example.superscribe" || result.Receipt.ApplicationVersion() != "42" {
+		t.Errorf("Should decode app, not %s %s", result.Receipt.BundleID(), result.Receipt.ApplicationVersion())
+	}
+	if result.Environment != EnvironmentSandbox {
+		t.Errorf("Should decode sandbox receipt type, not %s", result.Environment)
+	}
+
+	if len(result.AllTransactions()) != 2 || result.TransactionID() != "123456789012346" {
+		t.Errorf("Should decode 2 in-app purchases ending with the latest, not %s", result.TransactionID())
+	}
+	expiresAt := time.Date(2021, time.May, 1, 0, 0, 0, 0, time.UTC)
+	if !result.ExpiresAt().Equal(expiresAt) {
+		t.Errorf("Should parse %s as %s", result.ExpiresAt(), expiresAt)
+	}
+	if result.Quantity() != 1 || result.ProductID() != "month-premium" {
+		t.Errorf("Should decode in-app purchase, not %d %s", result.Quantity(), result.ProductID())
+	}
+	if len(result.InAppPurchases()) != 2 {
+		t.Errorf("Should list 2 in-app purchases, not %d", len(result.InAppPurchases()))
+	}
+}
+
+func TestVerifyLocalReceiptWithSignedAttributes(t *testing.T) {
+	data, rootCert := newTestLocalReceiptWith(t, testReceiptOptions{signedAttributes: true})
+
+	result, err := VerifyLocalReceipt(data, rootCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Receipt.ApplicationVersion() != "42" {
+		t.Errorf("Should decode app, not %s", result.Receipt.ApplicationVersion())
+	}
+
+	// Change the app version from 42 to 43 while keeping the signed message digest
+	for i := range data {
+		if data[i] == '4' && data[i+1] == '2' && data[i-1] == 2 {
+			data[i+1] = '3'
+			break
+		}
+	}
+	if _, err := VerifyLocalReceipt(data, rootCert); err == nil {
+		t.Error("Should reject a payload that doesn't match the signed message digest")
+	}
+}
+
+func TestVerifyLocalReceiptRejectsUnmarkedChain(t *testing.T) {
+	for _, options := range []testReceiptOptions{
+		{unmarkedLeaf: true},
+		{unmarkedIntermediate: true},
+	} {
+		data, rootCert := newTestLocalReceiptWith(t, options)
+		if _, err := VerifyLocalReceipt(data, rootCert); err == nil {
+			t.Errorf("Should reject a chain without Apple's markers, like %+v", options)
+		}
+	}
+}
+
+func TestVerifyLocalReceiptRejectsUntrustedRoot(t *testing.T) {
+	data, _ := newTestLocalReceipt(t)
+
+	if _, err := VerifyLocalReceipt(data, []byte(appleRootPEM)); err == nil {
+		t.Error("Should reject a receipt that doesn't chain up to the root certificate")
+	}
+}
+
+func TestVerifyLocalReceiptRejectsTamperedPayload(t *testing.T) {
+	data, rootCert := newTestLocalReceipt(t)
+
+	// Change the app version from 42 to 43 while keeping the original signature
+	for i := range data {
+		if data[i] == '4' && data[i+1] == '2' && data[i-1] == 2 {
+			data[i+1] = '3'
+			break
+		}
+	}
+
+	if _, err := VerifyLocalReceipt(data, rootCert); err == nil {
+		t.Error("Should reject a payload that doesn't match the signature")
+	}
+}
+
+func TestVerifyLocalReceiptRejectsMalformed(t *testing.T) {
+	_, rootCert := newTestLocalReceipt(t)
+
+	if _, err := VerifyLocalReceipt([]byte("receipt123"), rootCert); err == nil {
+		t.Error("Should reject data that isn't PKCS #7")
+	}
+}
+
+func TestParseReceipt(t *testing.T) {
+	data, _ := newTestLocalReceipt(t)
+
+	local, err := ParseReceipt(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if local.BundleID() != "com.example.superscribe" || local.Environment != EnvironmentSandbox {
+		t.Errorf("Should decode app, not %s %s", local.BundleID(), local.Environment)
+	}
+
+	createdAt := time.Date(2021, time.May, 15, 0, 0, 0, 0, time.UTC)
+	if !local.CreatedAt().Equal(createdAt) {
+		t.Errorf("Should parse %s as %s", local.CreatedAt(), createdAt)
+	}
+	if local.body.ReceiptCreationDate != newMillistamp(createdAt) {
+		t.Errorf("Should fill the receipt body's creation date, not %d", local.body.ReceiptCreationDate)
+	}
+
+	transactions := local.AllTransactions()
+	if len(transactions) != 2 || transactions[1].TransactionID() != "123456789012346" {
+		t.Errorf("Should decode 2 in-app purchases in order, not %v", transactions)
+	}
+}
+
+func TestParseReceiptRejectsMalformed(t *testing.T) {
+	for _, data := range []string{"!!!", base64.StdEncoding.EncodeToString([]byte("receipt123"))} {
+		if _, err := ParseReceipt(data); err == nil {
+			t.Errorf("Should reject malformed receipt %q", data)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package receipt
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// NotificationType is the event an App Store Server Notification reports
+type NotificationType string
+
+// Notification types sent by App Store Server Notifications v2
+// https://developer.apple.com/documentation/appstoreservernotifications/notificationtype
+const (
+	NotificationConsumptionRequest     NotificationType = "CONSUMPTION_REQUEST"
+	NotificationDidChangeRenewalPref   NotificationType = "DID_CHANGE_RENEWAL_PREF"
+	NotificationDidChangeRenewalStatus NotificationType = "DID_CHANGE_RENEWAL_STATUS"
+	NotificationDidFailToRenew         NotificationType = "DID_FAIL_TO_RENEW"
+	NotificationDidRenew               NotificationType = "DID_RENEW"
+	NotificationExpired                NotificationType = "EXPIRED"
+	NotificationGracePeriodExpired     NotificationType = "GRACE_PERIOD_EXPIRED"
+	NotificationOfferRedeemed          NotificationType = "OFFER_REDEEMED"
+	NotificationPriceIncrease          NotificationType = "PRICE_INCREASE"
+	NotificationRefund                 NotificationType = "REFUND"
+	NotificationRefundDeclined         NotificationType = "REFUND_DECLINED"
+	NotificationRenewalExtended        NotificationType = "RENEWAL_EXTENDED"
+	NotificationRenewalExtension       NotificationType = "RENEWAL_EXTENSION"
+	NotificationRevoke                 NotificationType = "REVOKE"
+	NotificationSubscribed             NotificationType = "SUBSCRIBED"
+	NotificationTest                   NotificationType = "TEST"
+)
+
+// NotificationSubtype further qualifies some notification types, or is empty
+type NotificationSubtype string
+
+// Notification subtypes sent by App Store Server Notifications v2
+// https://developer.apple.com/documentation/appstoreservernotifications/subtype
+const (
+	SubtypeAccepted          NotificationSubtype = "ACCEPTED"
+	SubtypeAutoRenewDisabled NotificationSubtype = "AUTO_RENEW_DISABLED"
+	SubtypeAutoRenewEnabled  NotificationSubtype = "AUTO_RENEW_ENABLED"
+	SubtypeBillingRecovery   NotificationSubtype = "BILLING_RECOVERY"
+	SubtypeBillingRetry      NotificationSubtype = "BILLING_RETRY"
+	SubtypeDowngrade         NotificationSubtype = "DOWNGRADE"
+	SubtypeFailure           NotificationSubtype = "FAILURE"
+	SubtypeGracePeriod       NotificationSubtype = "GRACE_PERIOD"
+	SubtypeInitialBuy        NotificationSubtype = "INITIAL_BUY"
+	SubtypePending           NotificationSubtype = "PENDING"
+	SubtypePriceIncrease     NotificationSubtype = "PRICE_INCREASE"
+	SubtypeResubscribe       NotificationSubtype = "RESUBSCRIBE"
+	SubtypeSummary           NotificationSubtype = "SUMMARY"
+	SubtypeUpgrade           NotificationSubtype = "UPGRADE"
+	SubtypeVoluntary         NotificationSubtype = "VOLUNTARY"
+)
+
+// NotificationBody models the signed payload of an App Store Server Notification v2
+// https://developer.apple.com/documentation/appstoreservernotifications/responsebodyv2decodedpayload
+type NotificationBody struct {
+	NotificationType NotificationType     `json:"notificationType"`
+	Subtype          NotificationSubtype  `json:"subtype"`
+	NotificationUUID string               `json:"notificationUUID"`
+	Version          string               `json:"version"`
+	SignedDate       Millistamp           `json:"signedDate"`
+	Data             NotificationData     `json:"data"`
+	Summary          *NotificationSummary `json:"summary"`
+}
+
+// NotificationData is the app and subscription data of a notification, with the transaction and
+// renewal info still signed separately
+type NotificationData struct {
+	AppAppleID            int64  `json:"appAppleId"`
+	BundleID              string `json:"bundleId"`
+	BundleVersion         string `json:"bundleVersion"`
+	Environment           string `json:"environment"`
+	SignedRenewalInfo     string `json:"signedRenewalInfo"`
+	SignedTransactionInfo string `json:"signedTransactionInfo"`
+	Status                int    `json:"status"`
+}
+
+// NotificationSummary reports the outcome of a request that applies to many customers at once,
+// like extending the renewal date of every subscription to a product, in place of data
+// https://developer.apple.com/documentation/appstoreservernotifications/summary
+type NotificationSummary struct {
+	RequestIdentifier      string   `json:"requestIdentifier"`
+	Environment            string   `json:"environment"`
+	AppAppleID             int64    `json:"appAppleId"`
+	BundleID               string   `json:"bundleId"`
+	ProductID              string   `json:"productId"`
+	StorefrontCountryCodes []string `json:"storefrontCountryCodes"`
+	FailedCount            int64    `json:"failedCount"`
+	SucceededCount         int64    `json:"succeededCount"`
+}
+
+// NotificationPayload tells whether a notification is about a single customer's transaction or
+// summarizes a request that applies to many
+type NotificationPayload string
+
+// Payloads of App Store Server Notifications v2, which carry either data or a summary
+const (
+	PayloadData    NotificationPayload = "data"
+	PayloadSummary NotificationPayload = "summary"
+)
+
+// JWSRenewalInfoBody models the signed renewal info payload
+// https://developer.apple.com/documentation/appstoreserverapi/jwsrenewalinfodecodedpayload
+type JWSRenewalInfoBody struct {
+	AutoRenewProductID     string           `json:"autoRenewProductId"`
+	AutoRenewStatus        int              `json:"autoRenewStatus"`
+	ExpirationIntent       ExpirationIntent `json:"expirationIntent"`
+	GracePeriodExpiresDate Millistamp       `json:"gracePeriodExpiresDate"`
+	IsInBillingRetryPeriod bool             `json:"isInBillingRetryPeriod"`
+	OfferIdentifier        string           `json:"offerIdentifier"`
+	OfferType              int              `json:"offerType"`
+	OriginalTransactionID  string           `json:"originalTransactionId"`
+	PriceIncreaseStatus    *int             `json:"priceIncreaseStatus"`
+	ProductID              string           `json:"productId"`
+	SignedDate             Millistamp       `json:"signedDate"`
+}
+
+// Notification is an App Store Server Notification v2 whose signatures have been verified.
+// Transaction and RenewalInfo are nil when the notification doesn't carry them, such as TEST,
+// and Summary is nil unless Payload is PayloadSummary, which has no transaction.
+type Notification struct {
+	NotificationType NotificationType
+	Subtype          NotificationSubtype
+	UUID             string
+	Version          string
+	SignedAt         time.Time
+	Payload          NotificationPayload
+
+	AppAppleID    int64
+	BundleID      string
+	BundleVersion string
+	Environment   string
+	Status        int
+
+	Transaction *SignedTransaction
+	RenewalInfo *PendingRenewalInfo
+	Summary     *NotificationSummary
+}
+
+// DecodeNotification checks the signature of an App Store Server Notification v2 signedPayload
+// and of the transaction and renewal info within its data, then decodes them.
+func DecodeNotification(signedPayload string) (Notification, error) {
+	return decodeNotification(signedPayload, appleRoots(), time.Now())
+}
+
+// DecodeNotification decodes a notification like the package's DecodeNotification, except that it
+// trusts the Client's RootCertificates and checks certificates at the Client's time. It also
+// evicts the results for the notification's transaction from the Client's Cache, which would
+// otherwise be stale.
+func (c *Client) DecodeNotification(signedPayload string) (Notification, error) {
+	note, err := decodeNotification(signedPayload, c.roots(), c.now())
+	if err != nil {
+		return note, err
+	}
+	if note.Transaction != nil {
+		c.InvalidateCache(note.Transaction.OriginalTransactionID())
+	}
+	return note, nil
+}
+
+func decodeNotification(signedPayload string, roots *x509.CertPool, now time.Time) (Notification, error) {
+	var body NotificationBody
+	if err := verifyJWS(signedPayload, roots, now, &body); err != nil {
+		return Notification{}, err
+	}
+
+	note := Notification{
+		NotificationType: body.NotificationType,
+		Subtype:          body.Subtype,
+		UUID:             body.NotificationUUID,
+		Version:          body.Version,
+		SignedAt:         body.SignedDate.Time(),
+		AppAppleID:       body.Data.AppAppleID,
+		BundleID:         body.Data.BundleID,
+		BundleVersion:    body.Data.BundleVersion,
+		Environment:      body.Data.Environment,
+		Status:           body.Data.Status,
+		Payload:          PayloadData,
+	}
+
+	if body.Summary != nil {
+		note.Payload = PayloadSummary
+		note.AppAppleID = body.Summary.AppAppleID
+		note.BundleID = body.Summary.BundleID
+		note.Environment = body.Summary.Environment
+		note.Summary = body.Summary
+		return note, nil
+	}
+
+	if body.Data.SignedTransactionInfo != "" {
+		transaction, err := decodeTransaction(body.Data.SignedTransactionInfo, roots, now)
+		if err != nil {
+			return Notification{}, err
+		}
+		note.Transaction = &transaction
+	}
+
+	if body.Data.SignedRenewalInfo != "" {
+		var renewal JWSRenewalInfoBody
+		if err := verifyJWS(body.Data.SignedRenewalInfo, roots, now, &renewal); err != nil {
+			return Notification{}, err
+		}
+		info := renewal.pendingRenewalInfo()
+		note.RenewalInfo = &info
+	}
+
+	return note, nil
+}
+
+// pendingRenewalInfo converts signed renewal info to the verifyReceipt representation
+func (body JWSRenewalInfoBody) pendingRenewalInfo() PendingRenewalInfo {
+	info := PendingRenewalInfo{
+		AutoRenewStatus:       body.AutoRenewStatus,
+		AutoRenewProductID:    body.AutoRenewProductID,
+		ExpirationIntent:      body.ExpirationIntent,
+		OriginalTransactionID: body.OriginalTransactionID,
+		PriceConsentStatus:    body.PriceIncreaseStatus,
+		ProductID:             body.ProductID,
+	}
+	if body.GracePeriodExpiresDate != 0 {
+		gracePeriodExpiresDate := body.GracePeriodExpiresDate
+		info.GracePeriodExpiresDate = &gracePeriodExpiresDate
+	}
+	if body.IsInBillingRetryPeriod {
+		info.IsInBillingRetryPeriod = 1
+	}
+	return info
+}
@@ -0,0 +1,187 @@
+package receipt
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestDecodeNotification(t *testing.T) {
+	signer := newTestSigner(t)
+
+	payload := map[string]interface{}{
+		"notificationType": "DID_RENEW",
+		"notificationUUID": "002e14d5-51f5-4503-b5a8-c3a1af68eb20",
+		"version":          "2.0",
+		"signedDate":       1622505600000,
+		"data": map[string]interface{}{
+			"appAppleId":    1234567890,
+			"bundleId":      "com.example.superscribe",
+			"bundleVersion": "42",
+			"environment":   EnvironmentSandbox,
+			"status":        1,
+			"signedTransactionInfo": signer.sign(t, map[string]interface{}{
+				"transactionId":         "2000000000000002",
+				"originalTransactionId": "2000000000000001",
+				"productId":             "month-premium",
+				"expiresDate":           1625097600000,
+			}),
+			"signedRenewalInfo": signer.sign(t, map[string]interface{}{
+				"autoRenewProductId":     "year-premium",
+				"autoRenewStatus":        1,
+				"gracePeriodExpiresDate": 1625702400000,
+				"isInBillingRetryPeriod": true,
+				"originalTransactionId":  "2000000000000001",
+				"productId":              "month-premium",
+			}),
+		},
+	}
+
+	note, err := decodeNotification(signer.sign(t, payload), signer.roots, jwsTestTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if note.NotificationType != NotificationDidRenew || note.Subtype != "" {
+		t.Errorf("Should decode DID_RENEW without subtype, not %s %s", note.NotificationType, note.Subtype)
+	}
+	if note.Payload != PayloadData || note.Summary != nil {
+		t.Errorf("Should decode a data payload, not %s", note.Payload)
+	}
+	if note.BundleID != "com.example.superscribe" || note.Environment != EnvironmentSandbox {
+		t.Errorf("Should decode app data, not %s %s", note.BundleID, note.Environment)
+	}
+
+	if note.Transaction == nil {
+		t.Fatal("Should decode signed transaction")
+	}
+	expiresAt := time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC)
+	if !note.Transaction.ExpiresAt().Equal(expiresAt) {
+		t.Errorf("Should parse %s as %s", note.Transaction.ExpiresAt(), expiresAt)
+	}
+
+	if note.RenewalInfo == nil {
+		t.Fatal("Should decode signed renewal info")
+	}
+	if note.RenewalInfo.AutoRenewProductID != "year-premium" || note.RenewalInfo.IsInBillingRetryPeriod != 1 {
+		t.Errorf("Should decode renewal info, not %+v", *note.RenewalInfo)
+	}
+	if !note.RenewalInfo.IsInGracePeriod(expiresAt) {
+		t.Error("Should be in grace period right after expiring")
+	}
+}
+
+func TestDecodeNotificationWithoutData(t *testing.T) {
+	signer := newTestSigner(t)
+	signed := signer.sign(t, map[string]interface{}{"notificationType": "TEST"})
+
+	note, err := decodeNotification(signed, signer.roots, jwsTestTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.NotificationType != NotificationTest || note.Transaction != nil || note.RenewalInfo != nil {
+		t.Errorf("Should decode TEST notification without transaction, not %+v", note)
+	}
+}
+
+func TestDecodeNotificationRejectsUntrustedTransaction(t *testing.T) {
+	signer := newTestSigner(t)
+	other := newTestSigner(t)
+
+	signed := signer.sign(t, map[string]interface{}{
+		"notificationType": "REFUND",
+		"data": map[string]interface{}{
+			"signedTransactionInfo": other.sign(t, map[string]string{"productId": "month-premium"}),
+		},
+	})
+
+	if _, err := decodeNotification(signed, signer.roots, jwsTestTime); err == nil {
+		t.Error("Should reject a transaction signed by another chain")
+	}
+}
+
+func TestDecodeNotificationV1(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/notification_v1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	note, err := DecodeNotificationV1(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if note.NotificationType != NotificationDidRenew || !note.AutoRenewStatus {
+		t.Errorf("Should decode DID_RENEW with auto-renew on, not %s", note.NotificationType)
+	}
+	if note.BundleID != "com.example.superscribe" || note.BundleVersion != "42" {
+		t.Errorf("Should decode app, not %s %s", note.BundleID, note.BundleVersion)
+	}
+
+	expiresAt := time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)
+	if !note.UnifiedReceipt.ExpiresAt().Equal(expiresAt) {
+		t.Errorf("Should parse latest expiration %s as %s", note.UnifiedReceipt.ExpiresAt(), expiresAt)
+	}
+	if len(note.UnifiedReceipt.AllTransactions()) != 2 || note.UnifiedReceipt.LatestReceipt != "latestreceipt==" {
+		t.Error("Should decode unified receipt")
+	}
+	if !note.UnifiedReceipt.AutoRenewStatus() {
+		t.Error("Should decode pending renewal info")
+	}
+}
+
+func TestDecodeNotificationV1WithoutUnifiedReceipt(t *testing.T) {
+	note, err := DecodeNotificationV1([]byte(`{"notification_type":"CANCEL","environment":"Sandbox"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.NotificationType != NotificationCancel || note.UnifiedReceipt.Info != nil {
+		t.Errorf("Should decode CANCEL without unified receipt, not %+v", note)
+	}
+}
+
+func TestDecodeNotificationSummary(t *testing.T) {
+	signer := newTestSigner(t)
+
+	payload := map[string]interface{}{
+		"notificationType": "RENEWAL_EXTENSION",
+		"subtype":          "SUMMARY",
+		"notificationUUID": "002e14d5-51f5-4503-b5a8-c3a1af68eb20",
+		"version":          "2.0",
+		"signedDate":       1622505600000,
+		"summary": map[string]interface{}{
+			"requestIdentifier":      "871d1cd8-bd1e-4d8a-a3a2-1e6b0a3bbd5b",
+			"environment":            EnvironmentProduction,
+			"appAppleId":             1234567890,
+			"bundleId":               "com.example.superscribe",
+			"productId":              "month-premium",
+			"storefrontCountryCodes": []string{"CAN", "USA"},
+			"failedCount":            2,
+			"succeededCount":         998,
+		},
+	}
+
+	note, err := decodeNotification(signer.sign(t, payload), signer.roots, jwsTestTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if note.NotificationType != NotificationRenewalExtension || note.Subtype != SubtypeSummary {
+		t.Errorf("Should decode RENEWAL_EXTENSION SUMMARY, not %s %s", note.NotificationType, note.Subtype)
+	}
+	if note.Payload != PayloadSummary || note.Summary == nil {
+		t.Fatalf("Should decode a summary payload, not %s", note.Payload)
+	}
+	if note.Transaction != nil || note.RenewalInfo != nil {
+		t.Error("Should not decode a transaction from a summary")
+	}
+
+	summary := note.Summary
+	if summary.ProductID != "month-premium" || summary.SucceededCount != 998 || summary.FailedCount != 2 ||
+		len(summary.StorefrontCountryCodes) != 2 {
+		t.Errorf("Should decode summary, not %+v", *summary)
+	}
+	if note.BundleID != "com.example.superscribe" || note.Environment != EnvironmentProduction {
+		t.Errorf("Should decode app from the summary, not %s %s", note.BundleID, note.Environment)
+	}
+}
@@ -0,0 +1,88 @@
+package receipt
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Notification types only sent by App Store Server Notifications v1. Version 1 also sends
+// CONSUMPTION_REQUEST, DID_CHANGE_RENEWAL_PREF, DID_CHANGE_RENEWAL_STATUS, DID_FAIL_TO_RENEW,
+// REFUND and REVOKE like version 2.
+// https://developer.apple.com/documentation/appstoreservernotifications/notification_type
+const (
+	NotificationCancel               NotificationType = "CANCEL"
+	NotificationDidRecover           NotificationType = "DID_RECOVER"
+	NotificationInitialBuy           NotificationType = "INITIAL_BUY"
+	NotificationInteractiveRenewal   NotificationType = "INTERACTIVE_RENEWAL"
+	NotificationPriceIncreaseConsent NotificationType = "PRICE_INCREASE_CONSENT"
+	NotificationRenewal              NotificationType = "RENEWAL"
+)
+
+// NotificationV1Body models the JSON body of an App Store Server Notification v1
+// https://developer.apple.com/documentation/appstoreservernotifications/responsebodyv1
+type NotificationV1Body struct {
+	AutoRenewProductID        string           `json:"auto_renew_product_id"`
+	AutoRenewStatus           bool             `json:"auto_renew_status,string"`
+	AutoRenewStatusChangeDate Millistamp       `json:"auto_renew_status_change_date_ms"`
+	Bid                       string           `json:"bid"`
+	Bvrs                      string           `json:"bvrs"`
+	Environment               string           `json:"environment"`
+	NotificationType          NotificationType `json:"notification_type"`
+	OriginalTransactionID     string           `json:"original_transaction_id"`
+	Password                  string           `json:"password"`
+	UnifiedReceipt            json.RawMessage  `json:"unified_receipt"`
+}
+
+// NotificationV1 is a decoded App Store Server Notification v1
+type NotificationV1 struct {
+	NotificationType NotificationType
+
+	// Environment is either Sandbox or PROD
+	Environment string
+
+	// Password is the shared secret submitted with verifyReceipt, which should match your own
+	Password string
+
+	AutoRenewProductID string
+	AutoRenewStatus    bool
+	AutoRenewChangedAt time.Time
+
+	BundleID              string
+	BundleVersion         string
+	OriginalTransactionID string
+
+	// UnifiedReceipt holds the latest transactions and renewal info as verifyReceipt would
+	// return them. Its Info is nil when the notification leaves out unified_receipt.
+	UnifiedReceipt VerifyResult
+}
+
+// DecodeNotificationV1 parses the JSON body of an App Store Server Notification v1.
+func DecodeNotificationV1(data []byte) (NotificationV1, error) {
+	var body NotificationV1Body
+	if err := json.Unmarshal(data, &body); err != nil {
+		return NotificationV1{}, err
+	}
+
+	note := NotificationV1{
+		NotificationType:      body.NotificationType,
+		Environment:           body.Environment,
+		Password:              body.Password,
+		AutoRenewProductID:    body.AutoRenewProductID,
+		AutoRenewStatus:       body.AutoRenewStatus,
+		AutoRenewChangedAt:    body.AutoRenewStatusChangeDate.Time(),
+		BundleID:              body.Bid,
+		BundleVersion:         body.Bvrs,
+		OriginalTransactionID: body.OriginalTransactionID,
+	}
+
+	// The unified receipt has the same shape as a verifyReceipt response
+	if len(body.UnifiedReceipt) > 0 {
+		result, err := parseReceiptResponse(body.UnifiedReceipt)
+		if err != nil {
+			return NotificationV1{}, err
+		}
+		note.UnifiedReceipt = result
+	}
+
+	return note, nil
+}
@@ -0,0 +1,24 @@
+package receipt
+
+import (
+	"time"
+)
+
+// StatusNoResponse is observed when a verifyReceipt request fails without a readable response
+const StatusNoResponse = -1
+
+// Observer receives metrics for each verifyReceipt request, including retries and the fallback
+// to the sandbox, such as to record them with Prometheus or OpenTelemetry.
+type Observer interface {
+
+	// ObserveVerify reports the App Store status or StatusNoResponse, the environment of the
+	// endpoint and how long the request took
+	ObserveVerify(status int, env string, d time.Duration)
+}
+
+// ObserverFunc adapts a func to an Observer
+type ObserverFunc func(status int, env string, d time.Duration)
+
+func (f ObserverFunc) ObserveVerify(status int, env string, d time.Duration) {
+	f(status, env, d)
+}
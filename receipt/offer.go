@@ -0,0 +1,76 @@
+package receipt
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// offerSeparator joins the fields of a promotional offer payload, as Apple requires
+const offerSeparator = "\u2063"
+
+// OfferSignature is what StoreKit needs to present a promotional offer, from
+// SKPaymentDiscount or Product.PurchaseOption.promotionalOffer
+type OfferSignature struct {
+	KeyID     string
+	Nonce     string
+	Timestamp int64
+	Signature string
+}
+
+// SignPromotionalOffer signs a subscription offer for the app with the in-app purchase key.
+// ApplicationUsername must match the appAccountToken or applicationUsername the app purchases
+// with, or be empty when it has none.
+// https://developer.apple.com/documentation/storekit/in-app_purchase/original_api_for_in-app_purchase/subscriptions_and_offers/generating_a_signature_for_promotional_offers
+func (k *APIKey) SignPromotionalOffer(productID, offerID, applicationUsername string) (OfferSignature, error) {
+	nonce, nonceErr := newUUID()
+	if nonceErr != nil {
+		return OfferSignature{}, nonceErr
+	}
+	return k.signPromotionalOffer(productID, offerID, applicationUsername, nonce, time.Now())
+}
+
+func (k *APIKey) signPromotionalOffer(productID, offerID, applicationUsername, nonce string,
+	now time.Time) (OfferSignature, error) {
+
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	payload := strings.Join([]string{
+		k.BundleID,
+		k.KeyID,
+		productID,
+		offerID,
+		strings.ToLower(applicationUsername),
+		strings.ToLower(nonce),
+		strconv.FormatInt(timestamp, 10),
+	}, offerSeparator)
+
+	// Unlike JWS, StoreKit expects the ASN.1 encoded signature
+	digest := sha256.Sum256([]byte(payload))
+	signature, signErr := ecdsa.SignASN1(rand.Reader, k.PrivateKey, digest[:])
+	if signErr != nil {
+		return OfferSignature{}, signErr
+	}
+
+	return OfferSignature{
+		KeyID:     k.KeyID,
+		Nonce:     nonce,
+		Timestamp: timestamp,
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}, nil
+}
+
+// newUUID generates a random version 4 UUID in lowercase
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,52 @@
+package receipt
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestSignPromotionalOffer(t *testing.T) {
+	key := newTestAPIKey(t)
+	now := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	offer, err := key.signPromotionalOffer("month-premium", "spring-promo", "User123",
+		"7E3FB20B-4CDB-47CC-936D-99D65F608138", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if offer.KeyID != "ABC123DEFG" || offer.Timestamp != 1622505600000 {
+		t.Errorf("Should return the key ID and timestamp, not %s %d", offer.KeyID, offer.Timestamp)
+	}
+
+	signature, decodeErr := base64.StdEncoding.DecodeString(offer.Signature)
+	if decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+	payload := "com.example.superscribe\u2063ABC123DEFG\u2063month-premium\u2063spring-promo\u2063user123" +
+		"\u20637e3fb20b-4cdb-47cc-936d-99d65f608138\u20631622505600000"
+	digest := sha256.Sum256([]byte(payload))
+	if !ecdsa.VerifyASN1(&key.PrivateKey.PublicKey, digest[:], signature) {
+		t.Error("Should sign the offer payload with the key")
+	}
+}
+
+func TestSignPromotionalOfferNonce(t *testing.T) {
+	key := newTestAPIKey(t)
+
+	first, err := key.SignPromotionalOffer("month-premium", "spring-promo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := key.SignPromotionalOffer("month-premium", "spring-promo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !isUUID(first.Nonce) || first.Nonce == second.Nonce {
+		t.Errorf("Should use a new UUID nonce for each offer, not %s and %s", first.Nonce, second.Nonce)
+	}
+}
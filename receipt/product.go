@@ -0,0 +1,26 @@
+package receipt
+
+// ProductType is the kind of in-app purchase a transaction is for
+type ProductType string
+
+// Product types, named as StoreKit 2 transactions name them
+// https://developer.apple.com/documentation/appstoreserverapi/type
+const (
+	ProductTypeAutoRenewable ProductType = "Auto-Renewable Subscription"
+	ProductTypeNonRenewing   ProductType = "Non-Renewing Subscription"
+	ProductTypeConsumable    ProductType = "Consumable"
+	ProductTypeNonConsumable ProductType = "Non-Consumable"
+
+	// ProductTypeOneTime is a purchase without an expiration date in a verifyReceipt response,
+	// which doesn't tell consumables, non-consumables and non-renewing subscriptions apart
+	ProductTypeOneTime ProductType = "One-Time"
+)
+
+// productTypeOf infers the product type of a receipt transaction, since only auto-renewable
+// subscriptions have an expiration date
+func productTypeOf(expiresDate Millistamp) ProductType {
+	if expiresDate != 0 {
+		return ProductTypeAutoRenewable
+	}
+	return ProductTypeOneTime
+}
@@ -0,0 +1,112 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited means the Client would exceed its RateLimit and RateLimitFailFast is set
+var ErrRateLimited = errors.New("verifyReceipt requests should stay within the Client's RateLimit")
+
+// rateLimiter is a token bucket that refills at rate tokens per second up to burst
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+// allow takes a token if one is available
+func (l *rateLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// reserve takes a token, going into debt if necessary, and returns how long until it's available
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel returns a reserved token that went unused
+func (l *rateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// wait blocks until the limiter allows a request or the context ends
+func (l *rateLimiter) wait(ctx context.Context) error {
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// throttle holds a verifyReceipt request to the Client's RateLimit
+func (c *Client) throttle(ctx context.Context) error {
+	if c.RateLimit <= 0 {
+		return nil
+	}
+
+	c.limiterOnce.Do(func() {
+		c.limiter = newRateLimiter(c.RateLimit, c.RateBurst)
+	})
+
+	if c.RateLimitFailFast {
+		if !c.limiter.allow(time.Now()) {
+			return ErrRateLimited
+		}
+		return nil
+	}
+	return c.limiter.wait(ctx)
+}
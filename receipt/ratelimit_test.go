@@ -0,0 +1,85 @@
+package receipt
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2019, time.April, 30, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(2, 2)
+
+	if !l.allow(now) || !l.allow(now) {
+		t.Error("Should allow a burst of 2")
+	}
+	if l.allow(now) {
+		t.Error("Should not allow more than the burst")
+	}
+	if !l.allow(now.Add(500 * time.Millisecond)) {
+		t.Error("Should allow another request once a token refills")
+	}
+
+	if delay := l.reserve(now.Add(500 * time.Millisecond)); delay != 500*time.Millisecond {
+		t.Errorf("Should wait for the next token, not %s", delay)
+	}
+	if delay := l.reserve(now.Add(500 * time.Millisecond)); delay != time.Second {
+		t.Errorf("Should wait behind the earlier reservation, not %s", delay)
+	}
+}
+
+func TestVerifyRateLimitFailFast(t *testing.T) {
+	srv := newTestServer(t, "response1.json")
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.RateLimit = 0.1
+	c.RateLimitFailFast = true
+
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Verify("receipt123"); err != ErrRateLimited {
+		t.Errorf("Should fail once over the rate limit, not %v", err)
+	}
+}
+
+func TestVerifyRateLimitWaits(t *testing.T) {
+	srv := newTestServer(t, "response1.json")
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.RateLimit = 50
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Verify("receipt123"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Should wait between requests over the rate limit, not %s", elapsed)
+	}
+}
+
+func TestVerifyRateLimitRespectsContext(t *testing.T) {
+	srv := newTestServer(t, "response1.json")
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.RateLimit = 0.1
+
+	if _, err := c.Verify("receipt123"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.VerifyContext(ctx, "receipt123"); err != context.DeadlineExceeded {
+		t.Errorf("Should stop waiting when the context ends, not %v", err)
+	}
+}
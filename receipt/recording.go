@@ -0,0 +1,118 @@
+package receipt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RecordingTransport records App Store responses to files in Dir, then replays them, such as to
+// record sandbox responses once and run integration tests in CI without an Apple account. Set it
+// as the Transport of the Client's HTTPClient. Recordings are named by a hash of each request
+// without the shared secret, so replaying works with any secret.
+type RecordingTransport struct {
+	Dir string
+
+	// Record sends requests with Transport, or http.DefaultTransport when nil, and saves each
+	// response. Otherwise requests are answered from the recordings.
+	Record    bool
+	Transport http.RoundTripper
+}
+
+type recordedResponse struct {
+	StatusCode int    `json:"status_code"`
+	Body       string `json:"body"`
+}
+
+// NewRecordingClient creates an HTTPClient that records responses to dir when record is set, and
+// otherwise replays them.
+func NewRecordingClient(dir string, record bool) *http.Client {
+	return &http.Client{Transport: &RecordingTransport{Dir: dir, Record: record}}
+}
+
+// LoadFixture reads a response saved in dir, such as testdata, by its file name.
+func LoadFixture(dir, name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(dir, name))
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var readErr error
+		if body, readErr = ioutil.ReadAll(req.Body); readErr != nil {
+			return nil, readErr
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	path := filepath.Join(t.Dir, recordingName(req, body))
+
+	if !t.Record {
+		data, readErr := ioutil.ReadFile(path)
+		if os.IsNotExist(readErr) {
+			return nil, fmt.Errorf("No response recorded for %s %s in %s", req.Method, req.URL, path)
+		} else if readErr != nil {
+			return nil, readErr
+		}
+
+		var recorded recordedResponse
+		if err := json.Unmarshal(data, &recorded); err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			Status:     http.StatusText(recorded.StatusCode),
+			StatusCode: recorded.StatusCode,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(recorded.Body)),
+			Request:    req,
+		}, nil
+	}
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, respErr := transport.RoundTrip(req)
+	if respErr != nil {
+		return nil, respErr
+	}
+
+	defer resp.Body.Close()
+	data, readErr := ioutil.ReadAll(resp.Body)
+	if readErr != nil {
+		return nil, readErr
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	recorded, marshalErr := json.MarshalIndent(recordedResponse{resp.StatusCode, string(data)}, "", "\t")
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, recorded, 0644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// recordingName hashes the request into a file name, leaving out the shared secret so that it
+// neither ends up on disk nor ties recordings to one secret
+func recordingName(req *http.Request, body []byte) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err == nil {
+		delete(fields, "password")
+		body, _ = json.Marshal(fields)
+	}
+
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.Host + req.URL.Path + "\n" + string(body)))
+	return hex.EncodeToString(sum[:8]) + ".json"
+}
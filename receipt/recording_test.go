@@ -0,0 +1,65 @@
+package receipt
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecordingTransport(t *testing.T) {
+	data, readErr := LoadFixture("testdata", "response1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Write(data)
+	}))
+
+	dir, dirErr := ioutil.TempDir("", "recordings")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.HTTPClient = NewRecordingClient(dir, true)
+
+	recorded, err := c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("Should record 1 response, not %d", len(files))
+	}
+	saved, _ := ioutil.ReadFile(dir + "/" + files[0].Name())
+	if string(saved) == "" || strings.Contains(string(saved), "password") {
+		t.Errorf("Should record the response without the shared secret, not %s", saved)
+	}
+
+	c = New("another-secret")
+	c.ProductionURL = srv.URL
+	c.HTTPClient = NewRecordingClient(dir, false)
+	c.Retry.MaxAttempts = 1
+
+	replayed, err := c.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 || replayed.ProductID() != recorded.ProductID() {
+		t.Errorf("Should replay %s without the server, not %s", recorded.ProductID(), replayed.ProductID())
+	}
+
+	if _, err := c.Verify("receipt456"); err == nil {
+		t.Error("Should fail to replay a receipt that wasn't recorded")
+	}
+}
@@ -0,0 +1,112 @@
+package receipt
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ExpirationIntent is why a subscription expired
+type ExpirationIntent int
+
+// https://developer.apple.com/documentation/appstorereceipts/expiration_intent
+const (
+	ExpirationIntentCancelled          ExpirationIntent = 1
+	ExpirationIntentBillingError       ExpirationIntent = 2
+	ExpirationIntentPriceIncrease      ExpirationIntent = 3
+	ExpirationIntentProductUnavailable ExpirationIntent = 4
+	ExpirationIntentUnknown            ExpirationIntent = 5
+)
+
+// Voluntary reports whether the customer chose to let the subscription expire, rather than a
+// billing error or the product becoming unavailable
+func (intent ExpirationIntent) Voluntary() bool {
+	return intent == ExpirationIntentCancelled || intent == ExpirationIntentPriceIncrease
+}
+
+// PendingRenewalInfo describes how an auto-renewable subscription is expected to renew
+// https://developer.apple.com/documentation/appstorereceipts/responsebody/pending_renewal_info
+type PendingRenewalInfo struct {
+	AutoRenewStatus        int              `json:"auto_renew_status,string"`
+	AutoRenewProductID     string           `json:"auto_renew_product_id"`
+	ExpirationIntent       ExpirationIntent `json:"expiration_intent,string,omitempty"`
+	GracePeriodExpiresDate *Millistamp      `json:"grace_period_expires_date_ms,omitempty"`
+	IsInBillingRetryPeriod int              `json:"is_in_billing_retry_period,string,omitempty"`
+	OriginalTransactionID  string           `json:"original_transaction_id"`
+	PriceConsentStatus     *int             `json:"price_consent_status,string,omitempty"`
+	PriceIncreaseStatus    *int             `json:"price_increase_status,string,omitempty"`
+	ProductID              string           `json:"product_id"`
+	PromotionalOfferID     string           `json:"promotional_offer_id,omitempty"`
+	OfferCodeRefName       string           `json:"offer_code_ref_name,omitempty"`
+}
+
+// decodeRenewalInfoList decodes pending renewal info, which rejects fields it doesn't have when
+// strict
+func decodeRenewalInfoList(data []byte, strict bool) ([]PendingRenewalInfo, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	type pendingRenewalInfo PendingRenewalInfo
+	infoList := make([]PendingRenewalInfo, len(list))
+	for i, infoData := range list {
+		formatted := struct {
+			*pendingRenewalInfo
+			FormattedGracePeriodExpiresDate *Millistamp     `json:"grace_period_expires_date"`
+			GracePeriodExpiresDatePST       json.RawMessage `json:"grace_period_expires_date_pst"`
+		}{pendingRenewalInfo: (*pendingRenewalInfo)(&infoList[i])}
+
+		if err := decodeJSON(infoData, &formatted, strict); err != nil {
+			return nil, err
+		}
+		if infoList[i].GracePeriodExpiresDate == nil && formatted.FormattedGracePeriodExpiresDate != nil &&
+			*formatted.FormattedGracePeriodExpiresDate != 0 {
+			infoList[i].GracePeriodExpiresDate = formatted.FormattedGracePeriodExpiresDate
+		}
+	}
+	return infoList, nil
+}
+
+// GracePeriodExpiresAt is when the billing grace period ends, to the millisecond, and reports
+// false when the subscription isn't in one
+func (info PendingRenewalInfo) GracePeriodExpiresAt() (time.Time, bool) {
+	if info.GracePeriodExpiresDate == nil || *info.GracePeriodExpiresDate == 0 {
+		return time.Time{}, false
+	}
+	return info.GracePeriodExpiresDate.Time(), true
+}
+
+// IsInGracePeriod reports whether Apple still grants access at the time now while retrying a
+// failed renewal charge
+func (info PendingRenewalInfo) IsInGracePeriod(now time.Time) bool {
+	expiresAt, ok := info.GracePeriodExpiresAt()
+	return ok && expiresAt.After(now)
+}
+
+// IsInBillingRetry reports whether Apple is still trying to charge a failed renewal, so the
+// subscription may recover without the customer doing anything
+func (info PendingRenewalInfo) IsInBillingRetry() bool {
+	return info.IsInBillingRetryPeriod == 1
+}
+
+// PriceIncreasePending reports whether the customer has yet to consent to a price increase, so
+// the subscription expires rather than renewing unless they do
+func (info PendingRenewalInfo) PriceIncreasePending() bool {
+	status := info.priceConsentStatus()
+	return status != nil && *status == 0
+}
+
+// PriceConsented reports whether the customer agreed to a price increase
+func (info PendingRenewalInfo) PriceConsented() bool {
+	status := info.priceConsentStatus()
+	return status != nil && *status == 1
+}
+
+// priceConsentStatus falls back to price_increase_status, which uses the same values, when the
+// App Store leaves out price_consent_status
+func (info PendingRenewalInfo) priceConsentStatus() *int {
+	if info.PriceConsentStatus != nil {
+		return info.PriceConsentStatus
+	}
+	return info.PriceIncreaseStatus
+}
@@ -0,0 +1,421 @@
+package receipt
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// VerifyResult is everything the App Store reports when verifying a receipt. The embedded Info
+// describes the latest transaction, along with the response status.
+type VerifyResult struct {
+	Info
+
+	// Environment is either EnvironmentProduction or EnvironmentSandbox
+	Environment string
+
+	// LatestReceipt is the freshest base64 encoded receipt, which should replace the stored one
+	// for future verification
+	LatestReceipt string
+
+	PendingRenewalInfo []PendingRenewalInfo
+	Receipt            Receipt
+
+	// RawResponse is the JSON the App Store responded with when the Client has KeepRawResponse,
+	// such as for an audit trail. Verify returns it along with errors for responses it couldn't
+	// parse. MarshalJSON leaves it out.
+	RawResponse []byte
+
+	transactions []Transaction
+}
+
+// AllTransactions lists every transaction in the response in chronological order, ending with
+// the latest one that Info describes
+func (r VerifyResult) AllTransactions() []Transaction {
+	transactions := make([]Transaction, len(r.transactions))
+	copy(transactions, r.transactions)
+	return transactions
+}
+
+// ForEachTransaction calls fn with each transaction in the order of AllTransactions without
+// copying them, and stops at the first error fn returns, which it returns
+func (r VerifyResult) ForEachTransaction(fn func(Transaction) error) error {
+	for _, transaction := range r.transactions {
+		if err := fn(transaction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenewalPeriod is one paid or trial period of a subscription, such as a row in a support tool
+type RenewalPeriod struct {
+	Start     time.Time
+	End       time.Time
+	ProductID string
+	IsTrial   bool
+}
+
+// RenewalTimeline lists the period of each transaction in AllTransactions from earliest start,
+// which audits the subscriber's history
+func (r VerifyResult) RenewalTimeline() []RenewalPeriod {
+	timeline := make([]RenewalPeriod, len(r.transactions))
+	for i, transaction := range r.transactions {
+		timeline[i] = RenewalPeriod{
+			Start:     transaction.PaidAt(),
+			End:       transaction.ExpiresAt(),
+			ProductID: transaction.ProductID(),
+			IsTrial:   transaction.IsTrialPeriod(),
+		}
+	}
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Start.Before(timeline[j].Start)
+	})
+	return timeline
+}
+
+// RenewalCount counts the paid periods the subscriber renewed into, such as for a loyalty program,
+// leaving out trials and the original purchase. AllTransactions lists each renewal once, though
+// without IncludeOldTransactions it may leave out old renewals.
+func (r VerifyResult) RenewalCount() int {
+	count := 0
+	for _, transaction := range r.transactions {
+		if transaction.IsTrialPeriod() || transaction.TransactionID() == transaction.OriginalTransactionID() {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// InAppPurchases lists every in-app purchase in the receipt object in chronological order. Unlike
+// AllTransactions, it includes one-time purchases, though without IncludeOldTransactions it may
+// leave out old subscription renewals.
+func (r VerifyResult) InAppPurchases() []Transaction {
+	infoList := make([]ReceiptInfoBody, len(r.Receipt.body.InApp))
+	copy(infoList, r.Receipt.body.InApp)
+	return newTransactions(infoList)
+}
+
+// LatestActiveTransaction finds the transaction for the product that hasn't been refunded and
+// expires last, preferring the most recent purchase among those expiring together. One-time
+// purchases never expire, so their latest unrefunded purchase counts as active.
+func (r VerifyResult) LatestActiveTransaction(productID string) (Transaction, bool) {
+	var latest Transaction
+	for _, transaction := range r.purchases() {
+		if transaction.ProductID() != productID || !transaction.CancelledAt().IsZero() {
+			continue
+		}
+		if latest == nil || !transaction.ExpiresAt().Before(latest.ExpiresAt()) {
+			latest = transaction
+		}
+	}
+	return latest, latest != nil
+}
+
+// EntitlementsByProduct maps each product in the receipt to its LatestActiveTransaction at the
+// time now, leaving out products whose every transaction was refunded and subscriptions that
+// expired outside a grace period
+func (r VerifyResult) EntitlementsByProduct(now time.Time) map[string]Transaction {
+	entitlements := make(map[string]Transaction)
+	for _, transaction := range r.purchases() {
+		// Receipts without subscriptions describe the receipt object in place of a transaction
+		productID := transaction.ProductID()
+		if _, ok := entitlements[productID]; ok || productID == "" {
+			continue
+		}
+		latest, ok := r.LatestActiveTransaction(productID)
+		if !ok {
+			continue
+		}
+		if expiresAt := latest.ExpiresAt(); !expiresAt.IsZero() && !expiresAt.After(now) &&
+			!r.isInGracePeriod(latest.OriginalTransactionID(), now) {
+			continue
+		}
+		entitlements[productID] = latest
+	}
+	return entitlements
+}
+
+// isInGracePeriod reports whether the renewal info for the subscription still grants access
+func (r VerifyResult) isInGracePeriod(originalTransactionID string, now time.Time) bool {
+	for _, info := range r.PendingRenewalInfo {
+		if info.OriginalTransactionID == originalTransactionID {
+			return info.IsInGracePeriod(now)
+		}
+	}
+	return false
+}
+
+// purchases adds the one-time purchases that only the receipt object lists to the transactions
+func (r VerifyResult) purchases() []Transaction {
+	purchases := r.AllTransactions()
+	for _, purchase := range r.InAppPurchases() {
+		if purchase.ProductType() != ProductTypeAutoRenewable {
+			purchases = append(purchases, purchase)
+		}
+	}
+	return purchases
+}
+
+// IsActive reports whether the subscription grants access at the time now, meaning it doesn't
+// expire, expires after now or is in a billing grace period, and App Store customer support hasn't
+// refunded it. A result without Info isn't active.
+func (r VerifyResult) IsActive(now time.Time) bool {
+	if r.Info == nil || !r.CancelledAt().IsZero() {
+		return false
+	}
+	return !r.hasExpired(now) || r.IsInGracePeriod(now)
+}
+
+// hasExpired reports whether the latest transaction expired by the time now, which purchases that
+// never expire don't. Info without a transaction or product, such as an app receipt without
+// purchases, never grants access.
+func (r VerifyResult) hasExpired(now time.Time) bool {
+	expiresAt := r.ExpiresAt()
+	if expiresAt.IsZero() {
+		return r.TransactionID() == "" && r.ProductID() == ""
+	}
+	return !expiresAt.After(now)
+}
+
+// DaysUntilExpiry counts the whole days left before the latest transaction expires, rounded down,
+// so it's negative as soon as the subscription expires. It reports false for purchases that never
+// expire and results without Info.
+func (r VerifyResult) DaysUntilExpiry(now time.Time) (int, bool) {
+	if r.Info == nil {
+		return 0, false
+	}
+	expiresAt := r.ExpiresAt()
+	if expiresAt.IsZero() {
+		return 0, false
+	}
+
+	const day = 24 * time.Hour
+	left := expiresAt.Sub(now)
+	days := left / day
+	if left%day < 0 {
+		days--
+	}
+	return int(days), true
+}
+
+// ReceiptCreationDate is when the App Store generated the receipt that was verified, such as to
+// reject receipts older than a threshold that may be replayed, or zero if the App Store left it out
+func (r VerifyResult) ReceiptCreationDate() time.Time {
+	return r.Receipt.CreatedAt()
+}
+
+// ExpiresSoon reports whether the subscription is still unexpired at the time now but expires
+// within the duration after, such as to find subscriptions for a renewal reminder. Refunded and
+// already expired subscriptions, purchases that never expire and results without Info don't
+// expire soon.
+func (r VerifyResult) ExpiresSoon(within time.Duration, now time.Time) bool {
+	if r.Info == nil || !r.CancelledAt().IsZero() {
+		return false
+	}
+	expiresAt := r.ExpiresAt()
+	return expiresAt.After(now) && expiresAt.Before(now.Add(within))
+}
+
+// ExpirationIntent explains why the subscription expired. It reports false while the subscription
+// is still active, since the App Store only includes the reason after it expires.
+func (r VerifyResult) ExpirationIntent() (ExpirationIntent, bool) {
+	renewal, ok := r.renewal()
+	if !ok || renewal.ExpirationIntent == 0 {
+		return 0, false
+	}
+	return renewal.ExpirationIntent, true
+}
+
+// GracePeriodExpiresAt is when the billing grace period of the subscription ends, or reports
+// false without one
+func (r VerifyResult) GracePeriodExpiresAt() (time.Time, bool) {
+	renewal, ok := r.renewal()
+	if !ok {
+		return time.Time{}, false
+	}
+	return renewal.GracePeriodExpiresAt()
+}
+
+func (r VerifyResult) IsInGracePeriod(now time.Time) bool {
+	renewal, ok := r.renewal()
+	return ok && renewal.IsInGracePeriod(now)
+}
+
+// IsInBillingRetry reports whether Apple is still trying to charge a failed renewal, such as to
+// keep access or hold off on win-back emails, or false without pending renewal info
+func (r VerifyResult) IsInBillingRetry() bool {
+	renewal, ok := r.renewal()
+	return ok && renewal.IsInBillingRetry()
+}
+
+// PriceIncreasePending reports whether the customer has yet to consent to a price increase, such as
+// to prompt them before the subscription lapses
+func (r VerifyResult) PriceIncreasePending() bool {
+	renewal, ok := r.renewal()
+	return ok && renewal.PriceIncreasePending()
+}
+
+func (r VerifyResult) PriceConsented() bool {
+	renewal, ok := r.renewal()
+	return ok && renewal.PriceConsented()
+}
+
+// NextRenewalProductID is the product the subscription renews to, which differs from the latest
+// transaction's after the customer changes plans, or empty without pending renewal info
+func (r VerifyResult) NextRenewalProductID() string {
+	renewal, _ := r.renewal()
+	return renewal.AutoRenewProductID
+}
+
+// WillAutoRenew reports whether the subscription is set to renew at its expiration date
+func (r VerifyResult) WillAutoRenew() bool {
+	renewal, ok := r.renewal()
+	return ok && renewal.AutoRenewStatus == 1
+}
+
+// renewal finds the pending renewal info for the latest transaction's subscription. Receipts with
+// subscriptions in several groups have an entry for each, told apart by original transaction ID.
+func (r VerifyResult) renewal() (PendingRenewalInfo, bool) {
+	for _, info := range r.PendingRenewalInfo {
+		if info.OriginalTransactionID == r.OriginalTransactionID() {
+			return info, true
+		}
+	}
+	return PendingRenewalInfo{}, false
+}
+
+// cachedResult is the stable JSON form of a VerifyResult, such as for caching, which normalizes
+// both receipt styles instead of following Apple's response format
+type cachedResult struct {
+	Status             int                  `json:"status"`
+	AutoRenewStatus    bool                 `json:"auto_renew_status"`
+	CancelledAt        time.Time            `json:"cancelled_at"`
+	Environment        string               `json:"environment"`
+	LatestReceipt      string               `json:"latest_receipt,omitempty"`
+	PendingRenewalInfo []PendingRenewalInfo `json:"pending_renewal_info,omitempty"`
+	Receipt            AppReceiptBody       `json:"receipt"`
+	Latest             *cachedTransaction   `json:"latest,omitempty"`
+	Transactions       []cachedTransaction  `json:"transactions,omitempty"`
+}
+
+type cachedTransaction struct {
+	CancelledAt           time.Time `json:"cancelled_at"`
+	CancellationReason    string    `json:"cancellation_reason,omitempty"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	IsInIntroOfferPeriod  bool      `json:"is_in_intro_offer_period"`
+	IsTrialPeriod         bool      `json:"is_trial_period"`
+	IsUpgraded            bool      `json:"is_upgraded"`
+	OriginalPurchaseDate  time.Time `json:"original_purchase_date"`
+	OriginalTransactionID string    `json:"original_transaction_id"`
+	PaidAt                time.Time `json:"paid_at"`
+	ProductID             string    `json:"product_id"`
+	PromotionalOfferID    string    `json:"promotional_offer_id,omitempty"`
+	Quantity              int       `json:"quantity"`
+	SubscriptionGroupID   string    `json:"subscription_group_id,omitempty"`
+	TransactionID         string    `json:"transaction_id"`
+	WebOrderLineItemID    string    `json:"web_order_line_item_id,omitempty"`
+}
+
+func newCachedTransaction(t Transaction) cachedTransaction {
+	cached := cachedTransaction{
+		CancelledAt:           t.CancelledAt().UTC(),
+		ExpiresAt:             t.ExpiresAt().UTC(),
+		IsInIntroOfferPeriod:  t.IsInIntroOfferPeriod(),
+		IsTrialPeriod:         t.IsTrialPeriod(),
+		IsUpgraded:            t.IsUpgraded(),
+		OriginalPurchaseDate:  t.OriginalPurchaseDate().UTC(),
+		OriginalTransactionID: t.OriginalTransactionID(),
+		PaidAt:                t.PaidAt().UTC(),
+		ProductID:             t.ProductID(),
+		PromotionalOfferID:    t.PromotionalOfferID(),
+		Quantity:              t.Quantity(),
+		SubscriptionGroupID:   t.SubscriptionGroupID(),
+		TransactionID:         t.TransactionID(),
+		WebOrderLineItemID:    t.WebOrderLineItemID(),
+	}
+	if reason, ok := t.CancellationReason(); ok {
+		cached.CancellationReason = strconv.Itoa(int(reason))
+	}
+	return cached
+}
+
+func (t cachedTransaction) transaction() Transaction {
+	body := ReceiptInfoBody{
+		Quantity:              strconv.Itoa(t.Quantity),
+		ProductID:             t.ProductID,
+		TransactionID:         t.TransactionID,
+		OriginalTransactionID: t.OriginalTransactionID,
+		PurchaseDate:          newMillistamp(t.PaidAt),
+		OriginalPurchaseDate:  newMillistamp(t.OriginalPurchaseDate),
+		IsTrialPeriod:         t.IsTrialPeriod,
+		IsInIntroOfferPeriod:  t.IsInIntroOfferPeriod,
+		IsUpgraded:            t.IsUpgraded,
+		PromotionalOfferID:    t.PromotionalOfferID,
+		SubscriptionGroupID:   t.SubscriptionGroupID,
+		ExpiresDate:           newMillistamp(t.ExpiresAt),
+		WebOrderLineItemID:    t.WebOrderLineItemID,
+	}
+	if cancellationDate := newMillistamp(t.CancelledAt); cancellationDate != 0 {
+		body.CancellationDate = &cancellationDate
+	}
+	if reason, err := strconv.Atoi(t.CancellationReason); err == nil {
+		body.CancellationReason = &reason
+	}
+	return modernReceiptInfo{body}
+}
+
+func (r VerifyResult) MarshalJSON() ([]byte, error) {
+	data := cachedResult{
+		Environment:        r.Environment,
+		LatestReceipt:      r.LatestReceipt,
+		PendingRenewalInfo: r.PendingRenewalInfo,
+		Receipt:            r.Receipt.body,
+	}
+	if r.Info != nil {
+		latest := newCachedTransaction(r.Info)
+		data.Status = r.Status()
+		data.AutoRenewStatus = r.AutoRenewStatus()
+		data.CancelledAt = r.CancelledAt().UTC()
+		data.Latest = &latest
+	}
+	for _, transaction := range r.transactions {
+		data.Transactions = append(data.Transactions, newCachedTransaction(transaction))
+	}
+	return json.Marshal(data)
+}
+
+// UnmarshalJSON restores a VerifyResult from MarshalJSON, whose accessors return the same values
+func (r *VerifyResult) UnmarshalJSON(b []byte) error {
+	var data cachedResult
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	var v validation
+	v.response.Status = data.Status
+	if data.AutoRenewStatus {
+		v.response.AutoRenewStatus = 1
+	}
+	if cancellationDate := newMillistamp(data.CancelledAt); cancellationDate != 0 {
+		v.response.CancellationDate = &cancellationDate
+	}
+	v.response.Environment = data.Environment
+	v.response.LatestReceipt = data.LatestReceipt
+	v.response.renewalInfo = data.PendingRenewalInfo
+	v.response.appReceipt = data.Receipt
+	for _, transaction := range data.Transactions {
+		v.response.transactions = append(v.response.transactions, transaction.transaction())
+	}
+
+	*r = v.result()
+	if data.Latest != nil {
+		v.response.info = data.Latest.transaction()
+		r.Info = v
+	} else {
+		r.Info = nil
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package receipt
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how a Client resends a receipt after a network error or while the App
+// Store reports it cannot read the request or is unavailable.
+type RetryPolicy struct {
+
+	// MaxAttempts bounds how many times a receipt is sent, including the first time
+	MaxAttempts int
+
+	// BaseDelay is the first wait between attempts, doubling each retry
+	BaseDelay time.Duration
+
+	// MaxElapsed bounds the total time spent on attempts and waits, or zero for no limit
+	MaxElapsed time.Duration
+
+	// InternalDataAccessAttempts and InternalDataAccessDelay replace MaxAttempts and BaseDelay
+	// while the App Store reports an internal data access error, which usually clears sooner than
+	// a network outage. Zero keeps MaxAttempts or BaseDelay.
+	InternalDataAccessAttempts int
+	InternalDataAccessDelay    time.Duration
+
+	// Jitter randomly varies each wait by up to this fraction, such as 0.2 for ±20%, so that
+	// many clients don't retry in lockstep
+	Jitter float64
+}
+
+// DefaultRetryPolicy tries a receipt 3 times, waiting 1 and then 2 seconds, or a quarter and then
+// half a second after internal data access errors
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:             3,
+	BaseDelay:               time.Second,
+	InternalDataAccessDelay: 250 * time.Millisecond,
+}
+
+// attempts is how many times to send a receipt that keeps failing with err
+func (p RetryPolicy) attempts(err error) int {
+	if isInternalDataAccess(err) && p.InternalDataAccessAttempts > 0 {
+		return p.InternalDataAccessAttempts
+	}
+	return p.MaxAttempts
+}
+
+// delay is how long to wait after the attempt, which counts from 1, failed with err
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	base := p.BaseDelay
+	if isInternalDataAccess(err) && p.InternalDataAccessDelay > 0 {
+		base = p.InternalDataAccessDelay
+	}
+
+	delay := base << uint(attempt-1)
+	if p.Jitter > 0 {
+		delay += time.Duration(p.Jitter * (2*rand.Float64() - 1) * float64(delay))
+	}
+	return delay
+}
+
+func isInternalDataAccess(err error) bool {
+	statusErr, ok := err.(StatusError)
+	return ok && statusErr.InternalDataAccess()
+}
@@ -0,0 +1,97 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrAlreadyProcessed is a receipt whose in-app purchases VerifyOnce already handled, such as
+// when an app retries submitting a consumable purchase
+var ErrAlreadyProcessed = errors.New("Transaction should not have been processed already")
+
+// SeenTransactions remembers which transactions have been handled, such as in a database table
+// keyed by transaction ID. To stay idempotent when the same receipt is verified concurrently,
+// MarkSeen should return ErrAlreadyProcessed for a transaction that's already marked, like on a
+// unique constraint violation.
+type SeenTransactions interface {
+	HasSeen(ctx context.Context, transactionID string) (bool, error)
+	MarkSeen(ctx context.Context, transactionID string) error
+}
+
+// VerifyOnce verifies the receipt like VerifyContext, then marks the in-app purchases that aren't
+// auto-renewable subscriptions, like consumables, seen in the Client's SeenTransactions and
+// returns the ones it hadn't seen before, so that each is credited once however many times the
+// app submits a receipt. It returns ErrAlreadyProcessed along with the result when it had seen
+// them all. Without SeenTransactions it's the same as VerifyContext and returns no purchases.
+// Failing to check or mark a transaction is a StoreError.
+func (c *Client) VerifyOnce(ctx context.Context, receipt string) (VerifyResult, []Transaction, error) {
+	result, err := c.VerifyContext(ctx, receipt)
+	if err != nil || c.SeenTransactions == nil {
+		return result, nil, err
+	}
+
+	var purchases []Transaction
+	for _, purchase := range result.InAppPurchases() {
+		if purchase.ProductType() == ProductTypeAutoRenewable {
+			continue
+		}
+		if purchase.TransactionID() == "" {
+			return result, nil, fmt.Errorf("In-app purchase of %s should have a transaction ID", purchase.ProductID())
+		}
+		purchases = append(purchases, purchase)
+	}
+
+	var unseen []Transaction
+	for _, purchase := range purchases {
+		seen, seenErr := c.SeenTransactions.HasSeen(ctx, purchase.TransactionID())
+		if seenErr != nil {
+			return result, unseen, StoreError{seenErr}
+		}
+		if seen {
+			continue
+		}
+
+		if err := c.SeenTransactions.MarkSeen(ctx, purchase.TransactionID()); errors.Is(err, ErrAlreadyProcessed) {
+			continue
+		} else if err != nil {
+			return result, unseen, StoreError{err}
+		}
+		unseen = append(unseen, purchase)
+	}
+
+	if len(purchases) > 0 && len(unseen) == 0 {
+		return result, nil, ErrAlreadyProcessed
+	}
+	return result, unseen, nil
+}
+
+// MemorySeenTransactions is SeenTransactions within the process, which suits a single server
+// that doesn't need to remember transactions across restarts
+type MemorySeenTransactions struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// NewMemorySeenTransactions creates an empty MemorySeenTransactions.
+func NewMemorySeenTransactions() *MemorySeenTransactions {
+	return &MemorySeenTransactions{seen: make(map[string]bool)}
+}
+
+func (s *MemorySeenTransactions) HasSeen(ctx context.Context, transactionID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen[transactionID], nil
+}
+
+func (s *MemorySeenTransactions) MarkSeen(ctx context.Context, transactionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seen[transactionID] {
+		return ErrAlreadyProcessed
+	}
+	s.seen[transactionID] = true
+	return nil
+}
@@ -0,0 +1,127 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type failingSeenTransactions struct{}
+
+func (failingSeenTransactions) HasSeen(ctx context.Context, transactionID string) (bool, error) {
+	return false, errors.New("database is down")
+}
+
+func (failingSeenTransactions) MarkSeen(ctx context.Context, transactionID string) error {
+	return errors.New("database is down")
+}
+
+func TestVerifyOnce(t *testing.T) {
+	srv := newTestServer(t, "response1.json")
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.VerifyOnce(context.Background(), "receipt123"); err != nil {
+			t.Fatalf("Should verify every time without SeenTransactions, not %v", err)
+		}
+	}
+
+	// Subscriptions renew under new transactions, so there's nothing to credit only once
+	seen := NewMemorySeenTransactions()
+	c.SeenTransactions = seen
+	for i := 0; i < 2; i++ {
+		if _, purchases, err := c.VerifyOnce(context.Background(), "receipt123"); err != nil || len(purchases) != 0 {
+			t.Errorf("Should verify a subscription every time, not %d purchases %v", len(purchases), err)
+		}
+	}
+	if len(seen.seen) != 0 {
+		t.Errorf("Should not mark subscriptions seen, not %v", seen.seen)
+	}
+}
+
+func TestVerifyOnceConsumables(t *testing.T) {
+	srv := newTestServer(t, "response10.json")
+	defer srv.Close()
+
+	seen := NewMemorySeenTransactions()
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.SeenTransactions = seen
+
+	// The app already credited the first purchase
+	seen.MarkSeen(context.Background(), "623456789012345")
+
+	result, purchases, err := c.VerifyOnce(context.Background(), "receipt123")
+	if err != nil {
+		t.Fatalf("Should process the new consumables the first time, not %v", err)
+	}
+	if len(purchases) != 2 || purchases[0].TransactionID() != "623456789012346" ||
+		purchases[1].TransactionID() != "623456789012347" {
+		t.Errorf("Should return the 2 consumables not yet seen, not %v", purchases)
+	}
+
+	again, purchases, err := c.VerifyOnce(context.Background(), "receipt123")
+	if err != ErrAlreadyProcessed || len(purchases) != 0 {
+		t.Errorf("Should refuse the consumables the second time, not %d purchases %v", len(purchases), err)
+	}
+	if len(again.InAppPurchases()) != len(result.InAppPurchases()) {
+		t.Error("Should return the result anyway")
+	}
+
+	// Another customer's receipt isn't mistaken for one already processed
+	other := newTestServer(t, "response4.json")
+	defer other.Close()
+	c.ProductionURL = other.URL
+	if _, purchases, err := c.VerifyOnce(context.Background(), "receipt456"); err != nil || len(purchases) != 1 {
+		t.Errorf("Should process another receipt's purchase, not %d purchases %v", len(purchases), err)
+	}
+
+	c.ProductionURL = srv.URL
+	c.SeenTransactions = failingSeenTransactions{}
+	var storeErr StoreError
+	if _, _, err := c.VerifyOnce(context.Background(), "receipt123"); !errors.As(err, &storeErr) {
+		t.Errorf("Should report SeenTransactions failing, not %v", err)
+	}
+}
+
+func TestVerifyOnceRequiresTransactionID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":0,"receipt":{"in_app":[{"product_id":"coins-100","purchase_date_ms":"1554076800000"}]}}`))
+	}))
+	defer srv.Close()
+
+	seen := NewMemorySeenTransactions()
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.SeenTransactions = seen
+
+	if _, _, err := c.VerifyOnce(context.Background(), "receipt123"); err == nil || err == ErrAlreadyProcessed {
+		t.Errorf("Should reject a purchase without a transaction ID, not %v", err)
+	}
+	if len(seen.seen) != 0 {
+		t.Errorf("Should not mark anything seen, not %v", seen.seen)
+	}
+}
+
+func TestMemorySeenTransactions(t *testing.T) {
+	seen := NewMemorySeenTransactions()
+	ctx := context.Background()
+
+	if ok, _ := seen.HasSeen(ctx, "1000000000000001"); ok {
+		t.Error("Should not have seen a new transaction")
+	}
+	if err := seen.MarkSeen(ctx, "1000000000000001"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := seen.HasSeen(ctx, "1000000000000001"); !ok {
+		t.Error("Should have seen a marked transaction")
+	}
+	if err := seen.MarkSeen(ctx, "1000000000000001"); err != ErrAlreadyProcessed {
+		t.Errorf("Should refuse to mark a transaction twice, not %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+package receipt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	apiSandboxURL    = "https://api.storekit-sandbox.itunes.apple.com"
+	apiProductionURL = "https://api.storekit.itunes.apple.com"
+
+	// apiTokenLifetime is how long each App Store Server API token is valid, within Apple's hour
+	apiTokenLifetime = 5 * time.Minute
+)
+
+// APIKey is an App Store Connect in-app purchase key, which signs App Store Server API requests
+type APIKey struct {
+	KeyID      string
+	IssuerID   string
+	BundleID   string
+	PrivateKey *ecdsa.PrivateKey
+}
+
+// errAPIKeyCurve rejects keys that can't sign ES256, which App Store Connect keys always can
+var errAPIKeyCurve = errors.New("API key should be on the P-256 curve for ES256")
+
+// ParseAPIKey reads the PEM encoded private key from the .p8 file App Store Connect provides.
+func ParseAPIKey(keyID, issuerID, bundleID string, p8 []byte) (*APIKey, error) {
+	block, _ := pem.Decode(p8)
+	if block == nil {
+		return nil, errors.New("API key should be PEM encoded")
+	}
+
+	parsed, parseErr := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	privateKey, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("API key should be an ECDSA private key")
+	}
+	if privateKey.Curve != elliptic.P256() {
+		return nil, errAPIKeyCurve
+	}
+
+	return &APIKey{KeyID: keyID, IssuerID: issuerID, BundleID: bundleID, PrivateKey: privateKey}, nil
+}
+
+type apiTokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+type apiTokenClaims struct {
+	Iss string `json:"iss"`
+	Iat int64  `json:"iat"`
+	Exp int64  `json:"exp"`
+	Aud string `json:"aud"`
+	Bid string `json:"bid"`
+}
+
+// token signs a JWT with ES256 that authorizes App Store Server API requests until it expires
+func (k *APIKey) token(now time.Time) (string, error) {
+	if k.PrivateKey == nil || k.PrivateKey.Curve != elliptic.P256() {
+		return "", errAPIKeyCurve
+	}
+
+	header, headerErr := json.Marshal(apiTokenHeader{Alg: "ES256", Kid: k.KeyID, Typ: "JWT"})
+	if headerErr != nil {
+		return "", headerErr
+	}
+
+	claims, claimsErr := json.Marshal(apiTokenClaims{
+		Iss: k.IssuerID,
+		Iat: now.Unix(),
+		Exp: now.Add(apiTokenLifetime).Unix(),
+		Aud: "appstoreconnect-v1",
+		Bid: k.BundleID,
+	})
+	if claimsErr != nil {
+		return "", claimsErr
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, signErr := ecdsa.Sign(rand.Reader, k.PrivateKey, digest[:])
+	if signErr != nil {
+		return "", signErr
+	}
+
+	// JWS encodes the ECDSA signature as fixed length r and s rather than ASN.1
+	signature := make([]byte, 64)
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(signature[32-len(rBytes):32], rBytes)
+	copy(signature[64-len(sBytes):], sBytes)
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+type transactionInfoResponse struct {
+	SignedTransactionInfo string `json:"signedTransactionInfo"`
+}
+
+// GetTransactionInfo looks up a transaction by its ID with the App Store Server API, then checks
+// its signature and decodes it. Like Verify, it asks production first and falls back to the
+// sandbox when production doesn't know the transaction.
+// https://developer.apple.com/documentation/appstoreserverapi/get_transaction_info
+func (c *Client) GetTransactionInfo(ctx context.Context, transactionID string) (SignedTransaction, error) {
+	return c.getTransactionInfo(ctx, transactionID, c.roots(), c.now())
+}
+
+func (c *Client) getTransactionInfo(ctx context.Context, transactionID string, roots *x509.CertPool,
+	now time.Time) (SignedTransaction, error) {
+
+	data, err := c.getAPI(ctx, "/inApps/v1/transactions/"+url.PathEscape(transactionID), now)
+	if err != nil {
+		return SignedTransaction{}, err
+	}
+
+	var resp transactionInfoResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return SignedTransaction{}, err
+	}
+
+	return decodeTransaction(resp.SignedTransactionInfo, roots, now)
+}
+
+// getAPI sends an authorized GET request to the App Store Server API, trying the other environment
+// after the first in the EnvironmentOrder responds 404, unless the Client is SandboxOnly or
+// StrictProduction keeps it out of the sandbox. Like Verify, it refuses endpoints without HTTPS.
+func (c *Client) getAPI(ctx context.Context, path string, now time.Time) ([]byte, error) {
+	if c.APIKey == nil {
+		return nil, errors.New("APIKey should have been set to call the App Store Server API")
+	}
+
+	for _, endpoint := range []string{c.APIProductionURL, c.APISandboxURL} {
+		if err := checkEndpoint(endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.checkEnvironments(); err != nil {
+		return nil, err
+	}
+
+	token, tokenErr := c.APIKey.token(now)
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+
+	if c.SandboxOnly {
+		return c.getAPIOnce(ctx, c.APISandboxURL+path, token)
+	}
+
+	apiURL, fallbackURL := c.APIProductionURL, c.APISandboxURL
+	if c.EnvironmentOrder == SandboxFirst {
+		apiURL, fallbackURL = c.APISandboxURL, c.APIProductionURL
+	}
+
+	data, err := c.getAPIOnce(ctx, apiURL+path, token)
+	if httpErr, ok := err.(HTTPError); ok && httpErr.StatusCode == http.StatusNotFound &&
+		!(c.StrictProduction && fallbackURL == c.APISandboxURL) {
+		return c.getAPIOnce(ctx, fallbackURL+path, token)
+	}
+	return data, err
+}
+
+// getAPIOnce sends a single App Store Server API request within the Client's Timeout
+func (c *Client) getAPIOnce(ctx context.Context, apiURL, token string) ([]byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	req, reqErr := http.NewRequest(http.MethodGet, apiURL, nil)
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	resp, respErr := c.httpClient().Do(req.WithContext(ctx))
+	if respErr != nil {
+		return nil, respErr
+	}
+
+	defer resp.Body.Close()
+
+	data, readErr := ioutil.ReadAll(resp.Body)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(data) > maxHTTPErrorBody {
+			data = data[:maxHTTPErrorBody]
+		}
+		return nil, HTTPError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+
+	return data, nil
+}
+
+// SubscriptionStatus is the state of an auto-renewable subscription in the App Store Server API
+type SubscriptionStatus int
+
+// Subscription statuses from the App Store Server API
+// https://developer.apple.com/documentation/appstoreserverapi/status
+const (
+	SubscriptionStatusActive       SubscriptionStatus = 1
+	SubscriptionStatusExpired      SubscriptionStatus = 2
+	SubscriptionStatusBillingRetry SubscriptionStatus = 3
+	SubscriptionStatusGracePeriod  SubscriptionStatus = 4
+	SubscriptionStatusRevoked      SubscriptionStatus = 5
+)
+
+// SubscriptionGroupStatus is the latest transaction of each subscription in a subscription group
+type SubscriptionGroupStatus struct {
+	SubscriptionGroupID string
+	LastTransactions    []LastTransaction
+}
+
+// LastTransaction is the status, latest transaction and renewal info of one subscription, whose
+// signatures have been verified
+type LastTransaction struct {
+	OriginalTransactionID string
+	Status                SubscriptionStatus
+	Transaction           SignedTransaction
+	RenewalInfo           PendingRenewalInfo
+}
+
+type subscriptionStatusesResponse struct {
+	Environment string `json:"environment"`
+	BundleID    string `json:"bundleId"`
+	Data        []struct {
+		SubscriptionGroupIdentifier string `json:"subscriptionGroupIdentifier"`
+		LastTransactions            []struct {
+			OriginalTransactionID string             `json:"originalTransactionId"`
+			Status                SubscriptionStatus `json:"status"`
+			SignedTransactionInfo string             `json:"signedTransactionInfo"`
+			SignedRenewalInfo     string             `json:"signedRenewalInfo"`
+		} `json:"lastTransactions"`
+	} `json:"data"`
+}
+
+// GetSubscriptionStatuses looks up every subscription of the customer who made the original
+// transaction with the App Store Server API, grouped by subscription group.
+// https://developer.apple.com/documentation/appstoreserverapi/get_all_subscription_statuses
+func (c *Client) GetSubscriptionStatuses(ctx context.Context,
+	originalTransactionID string) ([]SubscriptionGroupStatus, error) {
+
+	return c.getSubscriptionStatuses(ctx, originalTransactionID, c.roots(), c.now())
+}
+
+func (c *Client) getSubscriptionStatuses(ctx context.Context, originalTransactionID string,
+	roots *x509.CertPool, now time.Time) ([]SubscriptionGroupStatus, error) {
+
+	data, err := c.getAPI(ctx, "/inApps/v1/subscriptions/"+url.PathEscape(originalTransactionID), now)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp subscriptionStatusesResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+
+	groups := make([]SubscriptionGroupStatus, len(resp.Data))
+	for i, group := range resp.Data {
+		groups[i].SubscriptionGroupID = group.SubscriptionGroupIdentifier
+		groups[i].LastTransactions = make([]LastTransaction, len(group.LastTransactions))
+
+		for j, last := range group.LastTransactions {
+			transaction, transactionErr := decodeTransaction(last.SignedTransactionInfo, roots, now)
+			if transactionErr != nil {
+				return nil, transactionErr
+			}
+
+			var renewal JWSRenewalInfoBody
+			if err := verifyJWS(last.SignedRenewalInfo, roots, now, &renewal); err != nil {
+				return nil, err
+			}
+
+			groups[i].LastTransactions[j] = LastTransaction{
+				OriginalTransactionID: last.OriginalTransactionID,
+				Status:                last.Status,
+				Transaction:           transaction,
+				RenewalInfo:           renewal.pendingRenewalInfo(),
+			}
+		}
+	}
+
+	return groups, nil
+}
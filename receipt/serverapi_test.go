@@ -0,0 +1,291 @@
+package receipt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAPIKey(t *testing.T) *APIKey {
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+	return &APIKey{KeyID: "ABC123DEFG", IssuerID: "issuer123", BundleID: "com.example.superscribe", PrivateKey: key}
+}
+
+// checkAPIToken verifies the bearer token signature and returns its claims
+func checkAPIToken(t *testing.T, key *APIKey, r *http.Request) apiTokenClaims {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Should send a JWT, not %q", token)
+	}
+
+	signature, sigErr := base64.RawURLEncoding.DecodeString(parts[2])
+	if sigErr != nil || len(signature) != 64 {
+		t.Fatalf("Should sign with a 64 byte ES256 signature, not %q", parts[2])
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	rInt, sInt := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(&key.PrivateKey.PublicKey, digest[:], rInt, sInt) {
+		t.Error("Should sign the token with the API key")
+	}
+
+	var header apiTokenHeader
+	headerData, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Alg != "ES256" || header.Kid != key.KeyID {
+		t.Errorf("Should identify the API key in the header, not %v", header)
+	}
+
+	var claims apiTokenClaims
+	claimsData, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if err := json.Unmarshal(claimsData, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestGetTransactionInfo(t *testing.T) {
+	signer := newTestSigner(t)
+	key := newTestAPIKey(t)
+
+	var path string
+	var claims apiTokenClaims
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		claims = checkAPIToken(t, key, r)
+		json.NewEncoder(w).Encode(transactionInfoResponse{
+			SignedTransactionInfo: signer.sign(t, map[string]interface{}{
+				"transactionId": "2000000000000002",
+				"productId":     "month-premium",
+				"expiresDate":   1625097600000,
+			}),
+		})
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.APIKey = key
+	c.APIProductionURL = srv.URL
+
+	info, err := c.getTransactionInfo(context.Background(), "2000000000000002", signer.roots, jwsTestTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/inApps/v1/transactions/2000000000000002" {
+		t.Errorf("Should request the transaction, not %s", path)
+	}
+	if claims.Iss != "issuer123" || claims.Bid != "com.example.superscribe" || claims.Aud != "appstoreconnect-v1" {
+		t.Errorf("Should claim issuer, bundle and audience, not %v", claims)
+	}
+	if claims.Iat != jwsTestTime.Unix() || claims.Exp <= claims.Iat {
+		t.Errorf("Should issue the token now and expire it later, not %v", claims)
+	}
+	if info.TransactionID() != "2000000000000002" || info.ProductID() != "month-premium" {
+		t.Errorf("Should decode signed transaction, not %s %s", info.TransactionID(), info.ProductID())
+	}
+}
+
+func TestGetTransactionInfoFallsBackToSandbox(t *testing.T) {
+	signer := newTestSigner(t)
+
+	production := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"errorCode":4040010}`, http.StatusNotFound)
+	}))
+	defer production.Close()
+
+	sandbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(transactionInfoResponse{
+			SignedTransactionInfo: signer.sign(t, map[string]string{
+				"transactionId": "2000000000000002",
+				"environment":   "Sandbox",
+			}),
+		})
+	}))
+	defer sandbox.Close()
+
+	c := New("password")
+	c.APIKey = newTestAPIKey(t)
+	c.APIProductionURL = production.URL
+	c.APISandboxURL = sandbox.URL
+
+	info, err := c.getTransactionInfo(context.Background(), "2000000000000002", signer.roots, jwsTestTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TransactionID() != "2000000000000002" {
+		t.Errorf("Should find the transaction in the sandbox, not %s", info.TransactionID())
+	}
+
+	c.StrictProduction = true
+	_, err = c.getTransactionInfo(context.Background(), "2000000000000002", signer.roots, jwsTestTime)
+	if httpErr, ok := err.(HTTPError); !ok || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Should not fall back to the sandbox in strict production, not %v", err)
+	}
+}
+
+func TestGetTransactionInfoUsesNow(t *testing.T) {
+	key := newTestAPIKey(t)
+
+	var claims apiTokenClaims
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims = checkAPIToken(t, key, r)
+		json.NewEncoder(w).Encode(transactionInfoResponse{
+			SignedTransactionInfo: newTestSigner(t).sign(t, map[string]string{"transactionId": "2000000000000002"}),
+		})
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.APIKey = key
+	c.APIProductionURL = srv.URL
+	c.Now = func() time.Time { return jwsTestTime }
+
+	if _, err := c.GetTransactionInfo(context.Background(), "2000000000000002"); err == nil {
+		t.Error("Should reject a transaction not signed by Apple")
+	}
+	if claims.Iat != jwsTestTime.Unix() {
+		t.Errorf("Should issue the token at the Client's time, not %d", claims.Iat)
+	}
+}
+
+func TestGetTransactionInfoRequiresAPIKey(t *testing.T) {
+	if _, err := New("password").GetTransactionInfo(context.Background(), "2000000000000002"); err == nil {
+		t.Error("Should require an API key")
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	key := newTestAPIKey(t)
+	der, marshalErr := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if marshalErr != nil {
+		t.Fatal(marshalErr)
+	}
+	p8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	parsed, err := ParseAPIKey("ABC123DEFG", "issuer123", "com.example.superscribe", p8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.KeyID != "ABC123DEFG" || !parsed.PrivateKey.Equal(key.PrivateKey) {
+		t.Errorf("Should read the private key, not %v", parsed)
+	}
+
+	if _, err := ParseAPIKey("ABC123DEFG", "issuer123", "com.example.superscribe", []byte("key123")); err == nil {
+		t.Error("Should reject a key that isn't PEM encoded")
+	}
+
+	p384, keyErr := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+	der, marshalErr = x509.MarshalPKCS8PrivateKey(p384)
+	if marshalErr != nil {
+		t.Fatal(marshalErr)
+	}
+	p8 = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if _, err := ParseAPIKey("ABC123DEFG", "issuer123", "com.example.superscribe", p8); err == nil {
+		t.Error("Should reject a key that isn't on P-256")
+	}
+	if _, err := (&APIKey{KeyID: "ABC123DEFG", PrivateKey: p384}).token(time.Now()); err == nil {
+		t.Error("Should refuse to sign a token with a key that isn't on P-256")
+	}
+}
+
+func TestGetSubscriptionStatuses(t *testing.T) {
+	signer := newTestSigner(t)
+
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprintf(w, `{
+			"environment": "Production",
+			"bundleId": "com.example.superscribe",
+			"data": [{
+				"subscriptionGroupIdentifier": "20512345",
+				"lastTransactions": [{
+					"originalTransactionId": "2000000000000001",
+					"status": 4,
+					"signedTransactionInfo": %q,
+					"signedRenewalInfo": %q
+				}]
+			}]
+		}`,
+			signer.sign(t, map[string]interface{}{
+				"transactionId":         "2000000000000002",
+				"originalTransactionId": "2000000000000001",
+				"productId":             "month-premium",
+			}),
+			signer.sign(t, map[string]interface{}{
+				"originalTransactionId":  "2000000000000001",
+				"autoRenewProductId":     "month-premium",
+				"autoRenewStatus":        1,
+				"gracePeriodExpiresDate": 1625097600000,
+			}),
+		)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.APIKey = newTestAPIKey(t)
+	c.APIProductionURL = srv.URL
+
+	groups, err := c.getSubscriptionStatuses(context.Background(), "2000000000000001", signer.roots, jwsTestTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/inApps/v1/subscriptions/2000000000000001" {
+		t.Errorf("Should request the subscription statuses, not %s", path)
+	}
+	if len(groups) != 1 || groups[0].SubscriptionGroupID != "20512345" || len(groups[0].LastTransactions) != 1 {
+		t.Fatalf("Should decode 1 subscription group, not %v", groups)
+	}
+
+	last := groups[0].LastTransactions[0]
+	if last.Status != SubscriptionStatusGracePeriod || last.OriginalTransactionID != "2000000000000001" {
+		t.Errorf("Should decode grace period status, not %d %s", last.Status, last.OriginalTransactionID)
+	}
+	if last.Transaction.TransactionID() != "2000000000000002" {
+		t.Errorf("Should decode signed transaction, not %s", last.Transaction.TransactionID())
+	}
+	if last.RenewalInfo.AutoRenewStatus != 1 || last.RenewalInfo.GracePeriodExpiresDate == nil {
+		t.Errorf("Should decode signed renewal info, not %v", last.RenewalInfo)
+	}
+}
+
+func TestGetSubscriptionStatusesRejectsUntrustedRoot(t *testing.T) {
+	signer := newTestSigner(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data": [{"lastTransactions": [{"signedTransactionInfo": %q}]}]}`,
+			signer.sign(t, map[string]string{"transactionId": "2000000000000002"}))
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.APIKey = newTestAPIKey(t)
+	c.APIProductionURL = srv.URL
+
+	if _, err := c.getSubscriptionStatuses(context.Background(), "2000000000000001", appleRoots(), jwsTestTime); err == nil {
+		t.Error("Should reject transactions not signed by Apple")
+	}
+}
@@ -0,0 +1,95 @@
+package receipt
+
+import (
+	"time"
+)
+
+// SubscriptionState sums up a subscription for billing decisions
+type SubscriptionState int
+
+const (
+	// SubscriptionUnknown is the state of a result without Info, such as one returned with an error
+	SubscriptionUnknown SubscriptionState = iota
+	SubscriptionActive
+	SubscriptionTrial
+	SubscriptionInGracePeriod
+	SubscriptionExpiredVoluntarily
+	SubscriptionExpiredInvoluntarily
+	SubscriptionRefunded
+)
+
+// State sums up the subscription at the time now. The first state that applies wins:
+// refunded, then expired once any grace period has passed, then in a grace period, then in a free
+// trial, and otherwise active, which like IsActive includes purchases that never expire. An
+// expired subscription counts as voluntary only when its expiration intent says the customer
+// chose to leave.
+func (r VerifyResult) State(now time.Time) SubscriptionState {
+	switch {
+	case r.Info == nil:
+		return SubscriptionUnknown
+	case !r.CancelledAt().IsZero():
+		return SubscriptionRefunded
+	case r.hasExpired(now) && !r.IsInGracePeriod(now):
+		if intent, ok := r.ExpirationIntent(); ok && intent.Voluntary() {
+			return SubscriptionExpiredVoluntarily
+		}
+		return SubscriptionExpiredInvoluntarily
+	case r.hasExpired(now):
+		return SubscriptionInGracePeriod
+	case r.IsTrialPeriod():
+		return SubscriptionTrial
+	default:
+		return SubscriptionActive
+	}
+}
+
+// SubscriptionEvent is a change in a subscription between two verifications
+type SubscriptionEvent int
+
+const (
+	EventNone SubscriptionEvent = iota
+	EventRenewed
+	EventEnteredGracePeriod
+	EventExpired
+	EventRefunded
+	EventUpgraded
+)
+
+// Transition compares a subscription verified at prevAt with the same subscription verified at
+// currAt, such as for churn analytics without keeping a full history. The first event that applies
+// wins: refunded, then upgraded or renewed into a new transaction, then entered a grace period,
+// then expired. Without Info in both results, such as on the first check, there's no event.
+func Transition(prev VerifyResult, prevAt time.Time, curr VerifyResult, currAt time.Time) SubscriptionEvent {
+	if prev.Info == nil || curr.Info == nil {
+		return EventNone
+	}
+
+	sameTransaction := curr.TransactionID() == prev.TransactionID()
+	if !curr.CancelledAt().IsZero() && (!sameTransaction || prev.CancelledAt().IsZero()) {
+		return EventRefunded
+	}
+
+	if !sameTransaction {
+		for _, transaction := range curr.transactions {
+			if transaction.TransactionID() == prev.TransactionID() && transaction.IsUpgraded() {
+				return EventUpgraded
+			}
+		}
+		if curr.ExpiresAt().After(prev.ExpiresAt()) {
+			return EventRenewed
+		}
+	}
+
+	prevState, currState := prev.State(prevAt), curr.State(currAt)
+	switch {
+	case currState == SubscriptionInGracePeriod && prevState != SubscriptionInGracePeriod:
+		return EventEnteredGracePeriod
+	case currState.expired() && !prevState.expired():
+		return EventExpired
+	}
+	return EventNone
+}
+
+func (s SubscriptionState) expired() bool {
+	return s == SubscriptionExpiredVoluntarily || s == SubscriptionExpiredInvoluntarily
+}
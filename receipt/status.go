@@ -1,5 +1,9 @@
 package receipt
 
+import (
+	"fmt"
+)
+
 // https://developer.apple.com/library/archive/releasenotes/General/ValidateAppStoreReceipt/Chapters/ValidateRemotely.html#//apple_ref/doc/uid/TP40010573-CH104-SW1
 const (
 	StatusValid               = 0
@@ -12,4 +16,90 @@ const (
 	StatusReceiptFromTest     = 21007
 	StatusReceiptFromProd     = 21008
 	StatusUnauthorized        = 21010
+
+	// Apple reports internal data access errors anywhere in this range
+	StatusInternalDataAccessFirst = 21100
+	StatusInternalDataAccessLast  = 21199
 )
+
+var statusMessages = map[int]string{
+	StatusUnreadable:          "The App Store could not read the JSON object you provided.",
+	StatusReceiptMalformed:    "The data in the receipt-data property was malformed or missing.",
+	StatusNotAuthenticated:    "The receipt could not be authenticated.",
+	StatusMismatchedSecret:    "The shared secret you provided does not match the shared secret on file for your account.",
+	StatusUnreachable:         "The receipt server is not currently available.",
+	StatusSubscriptionExpired: "This receipt is valid but the subscription has expired.",
+	StatusReceiptFromTest:     "This receipt is from the test environment, but it was sent to the production environment for verification. Send it to the test environment instead.",
+	StatusReceiptFromProd:     "This receipt is from the production environment, but it was sent to the test environment for verification. Send it to the production environment instead.",
+	StatusUnauthorized:        "This receipt could not be authorized. Treat this the same as if a purchase was never made.",
+}
+
+// Errors for each verifyReceipt status, which callers can match with errors.Is
+var (
+	ErrUnreadable       = StatusError{StatusUnreadable}
+	ErrReceiptMalformed = StatusError{StatusReceiptMalformed}
+	ErrNotAuthenticated = StatusError{StatusNotAuthenticated}
+	ErrMismatchedSecret = StatusError{StatusMismatchedSecret}
+	ErrUnreachable      = StatusError{StatusUnreachable}
+	ErrReceiptFromTest  = StatusError{StatusReceiptFromTest}
+	ErrReceiptFromProd  = StatusError{StatusReceiptFromProd}
+)
+
+// ErrReceiptUnauthorized means the App Store no longer recognizes the receipt, such as after Apple
+// revoked the account or the customer deleted it, so any content it unlocked should be deprovisioned
+var ErrReceiptUnauthorized = StatusError{StatusUnauthorized}
+
+// ErrInternalDataAccess matches a StatusError for any internal data access error status
+var ErrInternalDataAccess = StatusError{StatusInternalDataAccessFirst}
+
+// maxHTTPErrorBody limits how much of an error page HTTPError keeps
+const maxHTTPErrorBody = 512
+
+// HTTPError is an unsuccessful HTTP response from a verifyReceipt endpoint, such as when Apple's
+// load balancer reports an outage instead of returning a receipt status
+type HTTPError struct {
+	StatusCode int
+
+	// Body is the start of the response body
+	Body string
+}
+
+func (e HTTPError) Error() string {
+	return fmt.Sprintf("The App Store responded with HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the App Store had a server error, which may succeed when retried
+func (e HTTPError) Temporary() bool {
+	return e.StatusCode >= 500
+}
+
+// StatusError is a verifyReceipt status that prevented reading the receipt
+type StatusError struct {
+	Status int
+}
+
+func (e StatusError) Error() string {
+	if msg, ok := statusMessages[e.Status]; ok {
+		return msg
+	}
+	if e.InternalDataAccess() {
+		return fmt.Sprintf("The App Store had an internal data access error with status %d.", e.Status)
+	}
+	return fmt.Sprintf("The App Store returned status %d.", e.Status)
+}
+
+// InternalDataAccess reports whether the status is one of Apple's internal data access errors
+func (e StatusError) InternalDataAccess() bool {
+	return e.Status >= StatusInternalDataAccessFirst && e.Status <= StatusInternalDataAccessLast
+}
+
+// Is matches ErrInternalDataAccess for every internal data access error status
+func (e StatusError) Is(target error) bool {
+	t, ok := target.(StatusError)
+	return ok && t == ErrInternalDataAccess && e.InternalDataAccess()
+}
+
+// Temporary reports whether sending the same receipt again may succeed
+func (e StatusError) Temporary() bool {
+	return e.Status == StatusUnreadable || e.Status == StatusUnreachable || e.InternalDataAccess()
+}
@@ -0,0 +1,39 @@
+package receipt
+
+import (
+	"context"
+)
+
+// Store persists verified results, such as by upserting the subscription into a database
+type Store interface {
+	Save(ctx context.Context, result VerifyResult) error
+}
+
+// StoreError is a Store failing to save a result that the App Store verified, as opposed to the
+// receipt failing to verify
+type StoreError struct {
+	Err error
+}
+
+func (e StoreError) Error() string {
+	return "Should have saved verified receipt: " + e.Err.Error()
+}
+
+func (e StoreError) Unwrap() error {
+	return e.Err
+}
+
+// VerifyAndStore verifies the receipt like VerifyContext, then saves the result to the Client's
+// Store when set. It returns the verified result even when saving fails with a StoreError, such
+// as to grant access anyway and save it later.
+func (c *Client) VerifyAndStore(ctx context.Context, receipt string) (VerifyResult, error) {
+	result, err := c.VerifyContext(ctx, receipt)
+	if err != nil || c.Store == nil {
+		return result, err
+	}
+
+	if err := c.Store.Save(ctx, result); err != nil {
+		return result, StoreError{err}
+	}
+	return result, nil
+}
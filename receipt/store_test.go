@@ -0,0 +1,80 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testStore struct {
+	saved []VerifyResult
+	err   error
+}
+
+func (s *testStore) Save(ctx context.Context, result VerifyResult) error {
+	s.saved = append(s.saved, result)
+	return s.err
+}
+
+func TestVerifyAndStore(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response1.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := New("password")
+	c.ProductionURL = srv.URL
+
+	if _, err := c.VerifyAndStore(context.Background(), "receipt123"); err != nil {
+		t.Fatalf("Should verify without a store, not %v", err)
+	}
+
+	store := &testStore{}
+	c.Store = store
+
+	result, err := c.VerifyAndStore(context.Background(), "receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 || store.saved[0].ProductID() != result.ProductID() {
+		t.Errorf("Should save the verified result, not %v", store.saved)
+	}
+
+	saveErr := errors.New("database is unavailable")
+	store.err = saveErr
+
+	result, err = c.VerifyAndStore(context.Background(), "receipt123")
+	if _, ok := err.(StoreError); !ok || !errors.Is(err, saveErr) {
+		t.Errorf("Should return a StoreError, not %v", err)
+	}
+	if result.ProductID() != "year-premium" {
+		t.Errorf("Should return the verified result along with the StoreError, not %s", result.ProductID())
+	}
+}
+
+func TestVerifyAndStoreSkipsFailedReceipts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":21002}`))
+	}))
+	defer srv.Close()
+
+	store := &testStore{}
+	c := New("password")
+	c.ProductionURL = srv.URL
+	c.Store = store
+
+	if _, err := c.VerifyAndStore(context.Background(), "receipt123"); !errors.Is(err, ErrReceiptMalformed) {
+		t.Errorf("Should return the verification error, not %v", err)
+	}
+	if len(store.saved) != 0 {
+		t.Error("Should not save a receipt that failed to verify")
+	}
+}
@@ -0,0 +1,234 @@
+package receipt
+
+import (
+	"crypto/x509"
+	"strings"
+	"time"
+)
+
+// JWSTransactionBody models the signed transaction payload from StoreKit 2
+// https://developer.apple.com/documentation/appstoreserverapi/jwstransactiondecodedpayload
+type JWSTransactionBody struct {
+	AppAccountToken             string     `json:"appAccountToken"`
+	BundleID                    string     `json:"bundleId"`
+	Environment                 string     `json:"environment"`
+	ExpiresDate                 Millistamp `json:"expiresDate"`
+	InAppOwnershipType          string     `json:"inAppOwnershipType"`
+	IsUpgraded                  bool       `json:"isUpgraded"`
+	OfferDiscountType           string     `json:"offerDiscountType"`
+	OfferIdentifier             string     `json:"offerIdentifier"`
+	OfferType                   int        `json:"offerType"`
+	OriginalPurchaseDate        Millistamp `json:"originalPurchaseDate"`
+	OriginalTransactionID       string     `json:"originalTransactionId"`
+	ProductID                   string     `json:"productId"`
+	PurchaseDate                Millistamp `json:"purchaseDate"`
+	Quantity                    int        `json:"quantity"`
+	RevocationDate              Millistamp `json:"revocationDate"`
+	RevocationReason            *int       `json:"revocationReason"`
+	SignedDate                  Millistamp `json:"signedDate"`
+	SubscriptionGroupIdentifier string     `json:"subscriptionGroupIdentifier"`
+	TransactionID               string     `json:"transactionId"`
+	Type                        string     `json:"type"`
+	WebOrderLineItemID          string     `json:"webOrderLineItemId"`
+}
+
+// Offer types for StoreKit 2 transactions
+const (
+	OfferTypeIntroductory = 1
+	OfferTypePromotional  = 2
+	OfferTypeCode         = 3
+)
+
+// Ownership types for StoreKit 2 transactions
+const (
+	OwnershipPurchased    = "PURCHASED"
+	OwnershipFamilyShared = "FAMILY_SHARED"
+)
+
+// SignedTransaction is a StoreKit 2 transaction whose JWS signature has been verified
+type SignedTransaction struct {
+	body JWSTransactionBody
+}
+
+// VerifyTransaction checks the signature of a StoreKit 2 JWS signed transaction and its
+// certificate chain up to the Apple root CA, then decodes the transaction.
+func (c *Client) VerifyTransaction(signedPayload string) (SignedTransaction, error) {
+	return decodeTransaction(signedPayload, c.roots(), c.now())
+}
+
+func decodeTransaction(signedPayload string, roots *x509.CertPool, now time.Time) (SignedTransaction, error) {
+	var body JWSTransactionBody
+	if err := verifyJWS(signedPayload, roots, now, &body); err != nil {
+		return SignedTransaction{}, err
+	}
+	return SignedTransaction{body}, nil
+}
+
+// AppAccountToken is the UUID the app attached to the purchase to tie it to the customer's account
+// on your server, or empty when the app didn't attach one or it isn't a UUID
+func (t SignedTransaction) AppAccountToken() string {
+	if !isUUID(t.body.AppAccountToken) {
+		return ""
+	}
+	return t.body.AppAccountToken
+}
+
+// isUUID reports whether s is a UUID in its canonical hyphenated hex form
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, r := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// AutoRenewStatus is always false because renewal status is signed separately from transactions
+func (t SignedTransaction) AutoRenewStatus() bool {
+	return false
+}
+
+// CancelledAt is when the App Store revoked the transaction, or zero if it hasn't
+func (t SignedTransaction) CancelledAt() time.Time {
+	return t.body.RevocationDate.Time()
+}
+
+func (t SignedTransaction) CancellationReason() (CancellationReason, bool) {
+	return newCancellationReason(t.body.RevocationReason)
+}
+
+// RevokedAt is when the App Store revoked the transaction, such as for a refund or after the
+// family organizer stopped sharing it, and reports false if it hasn't been revoked
+func (t SignedTransaction) RevokedAt() (time.Time, bool) {
+	return t.body.RevocationDate.Time(), t.body.RevocationDate != 0
+}
+
+// RevocationReason is 1 when the App Store refunded the transaction for a problem with the app and
+// 0 for any other reason, or when the transaction wasn't revoked
+func (t SignedTransaction) RevocationReason() int {
+	if t.body.RevocationReason == nil {
+		return 0
+	}
+	return *t.body.RevocationReason
+}
+
+// IsActive reports whether the transaction grants access at the time now, meaning it hasn't been
+// revoked and either doesn't expire or expires after now
+func (t SignedTransaction) IsActive(now time.Time) bool {
+	if _, revoked := t.RevokedAt(); revoked {
+		return false
+	}
+	return t.body.ExpiresDate == 0 || t.ExpiresAt().After(now)
+}
+
+func (t SignedTransaction) ExpiresAt() time.Time {
+	return t.body.ExpiresDate.Time()
+}
+
+func (t SignedTransaction) IsInIntroOfferPeriod() bool {
+	return t.body.OfferType == OfferTypeIntroductory
+}
+
+func (t SignedTransaction) IsInitialPurchase() bool {
+	return isInitialPurchase(t.body.TransactionID, t.body.OriginalTransactionID)
+}
+
+func (t SignedTransaction) IsTrialPeriod() bool {
+	return t.body.OfferType == OfferTypeIntroductory && t.body.OfferDiscountType == "FREE_TRIAL"
+}
+
+func (t SignedTransaction) IsUpgraded() bool {
+	return t.body.IsUpgraded
+}
+
+// OfferType is OfferTypeIntroductory, OfferTypePromotional or OfferTypeCode for the offer the
+// customer redeemed, or zero without an offer
+func (t SignedTransaction) OfferType() int {
+	return t.body.OfferType
+}
+
+// OfferIdentifier names the promotional offer or offer code the customer redeemed, or is empty
+// for introductory offers and transactions without an offer
+func (t SignedTransaction) OfferIdentifier() string {
+	return t.body.OfferIdentifier
+}
+
+// OwnershipType is OwnershipFamilyShared when a family member shares the purchase with the
+// customer, or OwnershipPurchased when the customer bought it
+func (t SignedTransaction) OwnershipType() string {
+	if t.body.InAppOwnershipType == "" {
+		return OwnershipPurchased
+	}
+	return t.body.InAppOwnershipType
+}
+
+// IsFamilyShared reports whether the customer has access through Family Sharing, which the
+// family organizer can revoke independently of their own purchase
+func (t SignedTransaction) IsFamilyShared() bool {
+	return t.OwnershipType() == OwnershipFamilyShared
+}
+
+func (t SignedTransaction) OriginalPurchaseDate() time.Time {
+	return t.body.OriginalPurchaseDate.Time()
+}
+
+func (t SignedTransaction) OriginalTransactionID() string {
+	return t.body.OriginalTransactionID
+}
+
+func (t SignedTransaction) PaidAt() time.Time {
+	return t.body.PurchaseDate.Time()
+}
+
+func (t SignedTransaction) ProductID() string {
+	return t.body.ProductID
+}
+
+func (t SignedTransaction) ProductType() ProductType {
+	if t.body.Type == "" {
+		return productTypeOf(t.body.ExpiresDate)
+	}
+	return ProductType(t.body.Type)
+}
+
+func (t SignedTransaction) PromotionalOfferID() string {
+	if t.body.OfferType == OfferTypePromotional {
+		return t.body.OfferIdentifier
+	}
+	return ""
+}
+
+// Quantity is 1 when the App Store leaves it out, like parseQuantity for receipts
+func (t SignedTransaction) Quantity() int {
+	if t.body.Quantity < 1 {
+		return 1
+	}
+	return t.body.Quantity
+}
+
+// Status is always StatusValid because the signature was verified
+func (t SignedTransaction) Status() int {
+	return StatusValid
+}
+
+func (t SignedTransaction) SubscriptionGroupID() string {
+	return t.body.SubscriptionGroupIdentifier
+}
+
+func (t SignedTransaction) TransactionID() string {
+	return t.body.TransactionID
+}
+
+func (t SignedTransaction) WebOrderLineItemID() string {
+	return t.body.WebOrderLineItemID
+}
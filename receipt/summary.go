@@ -0,0 +1,68 @@
+package receipt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+	"time"
+)
+
+// SharedSecretEnv names the environment variable cmd/verifyreceipt reads the shared secret from
+const SharedSecretEnv = "APP_STORE_SHARED_SECRET"
+
+// VerifySummary verifies a receipt read from r, either base64 text or the binary file, and writes
+// a summary of the result to w for a person to read, such as when debugging a single customer.
+func (c *Client) VerifySummary(ctx context.Context, r io.Reader, w io.Writer) error {
+	data, readErr := ioutil.ReadAll(r)
+	if readErr != nil {
+		return readErr
+	}
+
+	result, err := c.VerifyBytes(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	return WriteSummary(w, result, c.now())
+}
+
+// WriteSummary writes the product, status, expiry and environment of the result to w, aligned in
+// columns, with the expiry compared to the time now
+func WriteSummary(w io.Writer, r VerifyResult, now time.Time) error {
+	status := fmt.Sprint(r.Status())
+	if msg, ok := statusMessages[r.Status()]; ok {
+		status += " " + msg
+	}
+
+	expires := "never"
+	expiresAt := r.ExpiresAt()
+	if !expiresAt.IsZero() {
+		expires = expiresAt.UTC().Format(time.RFC3339)
+	}
+	switch {
+	case !r.CancelledAt().IsZero():
+		expires += " (refunded)"
+	case expiresAt.IsZero():
+		// Purchases that never expire are neither active nor expired
+	case r.IsActive(now):
+		expires += " (active)"
+	default:
+		expires += " (expired)"
+	}
+
+	autoRenew := "off"
+	if r.AutoRenewStatus() {
+		autoRenew = "on"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Product\t%s\n", r.ProductID())
+	fmt.Fprintf(tw, "Status\t%s\n", status)
+	fmt.Fprintf(tw, "Expires\t%s\n", expires)
+	fmt.Fprintf(tw, "Environment\t%s\n", r.Environment)
+	fmt.Fprintf(tw, "Original transaction\t%s\n", r.OriginalTransactionID())
+	fmt.Fprintf(tw, "Auto-renew\t%s\n", autoRenew)
+	return tw.Flush()
+}
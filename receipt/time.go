@@ -1,11 +1,60 @@
 package receipt
 
 import (
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Millistamp is an App Store date, which Apple sends as milliseconds since the epoch or as a
+// formatted date with a time zone name like "2013-08-01 07:00:00 Etc/GMT"
 type Millistamp int64
 
+const formattedDateLayout = "2006-01-02 15:04:05"
+
+// Time converts the date, or returns the zero time if the date was missing
 func (m Millistamp) Time() time.Time {
+	if m == 0 {
+		return time.Time{}
+	}
 	return time.Unix(0, int64(m)*int64(time.Millisecond))
 }
+
+// newMillistamp converts the time, keeping the zero time as a missing date
+func newMillistamp(t time.Time) Millistamp {
+	if t.IsZero() {
+		return 0
+	}
+	return Millistamp(t.UnixNano() / int64(time.Millisecond))
+}
+
+// UnmarshalJSON decodes any date format Apple uses. A missing or malformed date decodes as zero
+// rather than failing the whole receipt.
+func (m *Millistamp) UnmarshalJSON(data []byte) error {
+	*m = parseMillistamp(strings.Trim(string(data), `"`))
+	return nil
+}
+
+func parseMillistamp(value string) Millistamp {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return Millistamp(ms)
+	}
+
+	// Formatted dates end with the time zone name, like Etc/GMT or America/Los_Angeles
+	i := strings.LastIndex(value, " ")
+	if i < 0 {
+		return 0
+	}
+
+	loc, locErr := time.LoadLocation(value[i+1:])
+	if locErr != nil {
+		return 0
+	}
+
+	t, parseErr := time.ParseInLocation(formattedDateLayout, value[:i], loc)
+	if parseErr != nil {
+		return 0
+	}
+
+	return Millistamp(t.UnixNano() / int64(time.Millisecond))
+}
@@ -21,3 +21,111 @@ func TestUnmarshalMillistamp(t *testing.T) {
 		t.Errorf("%v should be the same as %v\n", sampleTime, data.Value.Time())
 	}
 }
+
+func TestUnmarshalAppleDates(t *testing.T) {
+	sampleTime := time.Date(2013, time.August, 1, 7, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		json     string
+		expected time.Time
+	}{
+		{"milliseconds", `{"value":"1375340400000"}`, sampleTime},
+		{"bare milliseconds", `{"value":1375340400000}`, sampleTime},
+		{"GMT", `{"value":"2013-08-01 07:00:00 Etc/GMT"}`, sampleTime},
+		{"PST", `{"value":"2013-08-01 00:00:00 America/Los_Angeles"}`, sampleTime},
+		{"empty", `{"value":""}`, time.Time{}},
+		{"malformed", `{"value":"yesterday"}`, time.Time{}},
+		{"unknown time zone", `{"value":"2013-08-01 07:00:00 Mars/Olympus"}`, time.Time{}},
+		{"missing", `{}`, time.Time{}},
+	}
+
+	for _, c := range cases {
+		var data struct {
+			Value Millistamp `json:"value"`
+		}
+
+		if err := json.Unmarshal([]byte(c.json), &data); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		} else if !c.expected.Equal(data.Value.Time()) {
+			t.Errorf("%s: %v should be the same as %v", c.name, data.Value.Time(), c.expected)
+		}
+	}
+}
+
+func TestMillistampRoundTrip(t *testing.T) {
+	sampleTime := time.Date(2019, time.March, 12, 10, 11, 12, 0, time.UTC)
+
+	var data struct {
+		Value Millistamp `json:"value_ms"`
+	}
+	data.Value = Millistamp(sampleTime.UnixNano() / int64(time.Millisecond))
+
+	encoded, marshalErr := json.Marshal(data)
+	if marshalErr != nil {
+		t.Fatal(marshalErr)
+	}
+
+	data.Value = 0
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		t.Error(err)
+	} else if !sampleTime.Equal(data.Value.Time()) {
+		t.Errorf("%v should be the same as %v", data.Value.Time(), sampleTime)
+	}
+}
+
+func TestReceiptInfoPrefersMillisecondDates(t *testing.T) {
+	expiresAt := time.Date(2019, time.May, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		json string
+	}{
+		{"both", `{"expires_date":"2019-05-01 00:00:00 Etc/GMT","expires_date_ms":"1556668800000"}`},
+		{"milliseconds only", `{"expires_date_ms":"1556668800000"}`},
+		{"formatted only", `{"expires_date":"2019-05-01 00:00:00 Etc/GMT"}`},
+		{"formatted in PDT", `{"expires_date":"2019-04-30 17:00:00 America/Los_Angeles"}`},
+		{"conflicting", `{"expires_date":"2019-05-01 00:00:00 America/Los_Angeles","expires_date_ms":"1556668800000"}`},
+	}
+
+	for _, c := range cases {
+		var body ReceiptInfoBody
+		if err := json.Unmarshal([]byte(c.json), &body); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		} else if !expiresAt.Equal(body.ExpiresDate.Time()) {
+			t.Errorf("%s: %v should be the same as %v", c.name, body.ExpiresDate.Time(), expiresAt)
+		}
+	}
+}
+
+func TestReceiptInfoFormattedDatesMatchMilliseconds(t *testing.T) {
+	var formatted, ms ReceiptInfoBody
+
+	formattedJSON := []byte(`{
+		"purchase_date": "2019-03-01 00:00:00 Etc/GMT",
+		"original_purchase_date": "2019-02-28 16:00:00 America/Los_Angeles",
+		"expires_date": "2019-04-01 00:00:00 Etc/GMT",
+		"cancellation_date": "2019-03-15 12:00:00 Etc/GMT"
+	}`)
+	msJSON := []byte(`{
+		"purchase_date_ms": "1551398400000",
+		"original_purchase_date_ms": "1551398400000",
+		"expires_date_ms": "1554076800000",
+		"cancellation_date_ms": "1552651200000"
+	}`)
+
+	if err := json.Unmarshal(formattedJSON, &formatted); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(msJSON, &ms); err != nil {
+		t.Fatal(err)
+	}
+
+	if formatted.PurchaseDate != ms.PurchaseDate || formatted.OriginalPurchaseDate != ms.OriginalPurchaseDate ||
+		formatted.ExpiresDate != ms.ExpiresDate {
+		t.Errorf("Formatted dates %+v should match millisecond dates %+v", formatted, ms)
+	}
+	if formatted.CancellationDate == nil || *formatted.CancellationDate != *ms.CancellationDate {
+		t.Error("Formatted cancellation date should match milliseconds")
+	}
+}
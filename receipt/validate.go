@@ -2,6 +2,7 @@ package receipt
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,28 +11,67 @@ import (
 	"log"
 	"net/http"
 	"sort"
+	"strconv"
 	"time"
 )
 
 type Info interface {
+	Transaction
 	Status() int
 	AutoRenewStatus() bool
+}
+
+// Transaction describes a single purchase or subscription renewal
+type Transaction interface {
+	// CancelledAt is when App Store customer support refunded the transaction, or zero when it
+	// wasn't refunded
 	CancelledAt() time.Time
+
+	// CancellationReason tells refunds for a problem with the app apart from other refunds, and
+	// reports false when the transaction wasn't refunded
+	CancellationReason() (CancellationReason, bool)
+
+	// ExpiresAt is zero for purchases that don't expire, like consumables
 	ExpiresAt() time.Time
+	IsInIntroOfferPeriod() bool
+
+	// IsInitialPurchase tells the customer's first purchase of a subscription or product apart
+	// from renewals and restores, which have their own TransactionID
+	IsInitialPurchase() bool
 	IsTrialPeriod() bool
+	IsUpgraded() bool
 	OriginalTransactionID() string
+
+	// OriginalPurchaseDate is when the customer first subscribed, which stays the same across
+	// renewals unlike PaidAt
 	OriginalPurchaseDate() time.Time
 	PaidAt() time.Time
 	ProductID() string
+	ProductType() ProductType
+	PromotionalOfferID() string
+	Quantity() int
+	SubscriptionGroupID() string
+	TransactionID() string
+
+	// WebOrderLineItemID identifies a subscription purchase across devices and restores, which
+	// makes a more stable key than TransactionID for deduplicating records
+	WebOrderLineItemID() string
 }
 
-type receipt interface {
-	ExpiresAt() time.Time
-	IsTrialPeriod() bool
-	OriginalTransactionID() string
-	OriginalPurchaseDate() time.Time
-	PaidAt() time.Time
-	ProductID() string
+// CancellationReason is why App Store customer support refunded a transaction
+type CancellationReason int
+
+// https://developer.apple.com/documentation/appstorereceipts/cancellation_reason
+const (
+	CancellationReasonOther    CancellationReason = 0
+	CancellationReasonAppIssue CancellationReason = 1
+)
+
+func newCancellationReason(reason *int) (CancellationReason, bool) {
+	if reason == nil {
+		return 0, false
+	}
+	return CancellationReason(*reason), true
 }
 
 type ReceiptInfoBody struct {
@@ -39,15 +79,101 @@ type ReceiptInfoBody struct {
 	ProductID             string      `json:"product_id"`
 	TransactionID         string      `json:"transaction_id"`
 	OriginalTransactionID string      `json:"original_transaction_id"`
-	PurchaseDate          Millistamp  `json:"purchase_date_ms,string"`
-	OriginalPurchaseDate  Millistamp  `json:"original_purchase_date_ms,string"`
-	CancellationDate      *Millistamp `json:"cancellation_date_ms,string,omitempty"`
+	PurchaseDate          Millistamp  `json:"purchase_date_ms"`
+	OriginalPurchaseDate  Millistamp  `json:"original_purchase_date_ms"`
+	CancellationDate      *Millistamp `json:"cancellation_date_ms,omitempty"`
+	CancellationReason    *int        `json:"cancellation_reason,string,omitempty"`
 	IsTrialPeriod         bool        `json:"is_trial_period,string"`
-	ExpiresDate           Millistamp  `json:"expires_date_ms,string"`
+	IsInIntroOfferPeriod  bool        `json:"is_in_intro_offer_period,string"`
+	IsUpgraded            bool        `json:"is_upgraded,string"`
+	PromotionalOfferID    string      `json:"promotional_offer_id"`
+	SubscriptionGroupID   string      `json:"subscription_group_identifier"`
+	WebOrderLineItemID    string      `json:"web_order_line_item_id"`
+	ExpiresDate           Millistamp  `json:"expires_date_ms"`
+	AppAccountToken       string      `json:"app_account_token,omitempty"`
+	InAppOwnershipType    string      `json:"in_app_ownership_type,omitempty"`
+	OfferCodeRefName      string      `json:"offer_code_ref_name,omitempty"`
 
 	InApp []ReceiptInfoBody `json:"in_app,omitempty"`
 }
 
+// UnmarshalJSON prefers the unambiguous _ms dates and only falls back to the formatted dates, whose
+// time zone names depend on the server's time zone database, when the _ms dates are missing.
+func (body *ReceiptInfoBody) UnmarshalJSON(data []byte) error {
+	return body.decode(data, false)
+}
+
+// decode is UnmarshalJSON, which rejects fields the receipt info doesn't have when strict
+func (body *ReceiptInfoBody) decode(data []byte, strict bool) error {
+	type receiptInfoBody ReceiptInfoBody
+	formatted := struct {
+		*receiptInfoBody
+		FormattedCancellationDate     *Millistamp `json:"cancellation_date"`
+		FormattedExpiresDate          Millistamp  `json:"expires_date"`
+		FormattedOriginalPurchaseDate Millistamp  `json:"original_purchase_date"`
+		FormattedPurchaseDate         Millistamp  `json:"purchase_date"`
+
+		// Apple repeats each date in Pacific time, which adds nothing to the other formats
+		CancellationDatePST     json.RawMessage `json:"cancellation_date_pst"`
+		ExpiresDatePST          json.RawMessage `json:"expires_date_pst"`
+		OriginalPurchaseDatePST json.RawMessage `json:"original_purchase_date_pst"`
+		PurchaseDatePST         json.RawMessage `json:"purchase_date_pst"`
+	}{receiptInfoBody: (*receiptInfoBody)(body)}
+
+	if err := decodeJSON(data, &formatted, strict); err != nil {
+		return err
+	}
+
+	if body.CancellationDate == nil && formatted.FormattedCancellationDate != nil &&
+		*formatted.FormattedCancellationDate != 0 {
+		body.CancellationDate = formatted.FormattedCancellationDate
+	}
+	if body.ExpiresDate == 0 {
+		body.ExpiresDate = formatted.FormattedExpiresDate
+	}
+	if body.OriginalPurchaseDate == 0 {
+		body.OriginalPurchaseDate = formatted.FormattedOriginalPurchaseDate
+	}
+	if body.PurchaseDate == 0 {
+		body.PurchaseDate = formatted.FormattedPurchaseDate
+	}
+	return nil
+}
+
+// decodeReceiptInfoList decodes a list of receipt info, which rejects fields the receipt info
+// doesn't have when strict
+func decodeReceiptInfoList(data []byte, strict bool) ([]ReceiptInfoBody, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	infoList := make([]ReceiptInfoBody, len(list))
+	for i, infoData := range list {
+		if err := infoList[i].decode(infoData, strict); err != nil {
+			return nil, err
+		}
+	}
+	return infoList, nil
+}
+
+// decodeJSON is json.Unmarshal, except that it fails on fields v doesn't have when strict
+func decodeJSON(data []byte, v interface{}, strict bool) error {
+	if !strict {
+		return json.Unmarshal(data, v)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("Should have reached the end of JSON")
+	}
+	return nil
+}
+
 type receiptInfo struct {
 	ReceiptInfoBody
 }
@@ -69,17 +195,31 @@ func (info receiptInfo) ProductID() string {
 }
 
 type response struct {
-	info receipt
+	info         Transaction
+	transactions []Transaction
 
+	AutoRenewProductID       string          `json:"auto_renew_product_id"`
 	AutoRenewStatus          int             `json:"auto_renew_status"`
-	CancellationDate         *Millistamp     `json:"cancellation_date_ms,string,omitempty"`
+	CancellationDate         *Millistamp     `json:"cancellation_date_ms,omitempty"`
+	Environment              string          `json:"environment"`
+	IsRetryable              bool            `json:"is-retryable"`
 	LatestExpiredReceiptInfo json.RawMessage `json:"latest_expired_receipt_info"`
+	LatestReceipt            string          `json:"latest_receipt"`
 	LatestReceiptInfo        json.RawMessage `json:"latest_receipt_info"`
 	Receipt                  json.RawMessage `json:"receipt"`
 	Status                   int             `json:"status"`
 
 	PendingRenewalInfo json.RawMessage `json:"pending_renewal_info"`
-	renewalInfo        renewalInfo
+	renewalInfo        []PendingRenewalInfo
+
+	// iOS 6 style responses repeat these from the receipt info, which they're read from instead
+	CancellationDateFormatted json.RawMessage `json:"cancellation_date"`
+	CancellationDatePST       json.RawMessage `json:"cancellation_date_pst"`
+	CancellationReason        json.RawMessage `json:"cancellation_reason"`
+	ExpirationIntent          json.RawMessage `json:"expiration_intent"`
+	IsInBillingRetryPeriod    json.RawMessage `json:"is_in_billing_retry_period"`
+
+	appReceipt AppReceiptBody
 }
 
 type validation struct {
@@ -90,24 +230,42 @@ type validation struct {
 }
 
 func (v validation) AutoRenewStatus() bool {
-	return v.response.renewalInfo.AutoRenewStatus == 1
+	r := v.response
+	return r.AutoRenewStatus == 1 || (len(r.renewalInfo) > 0 && r.renewalInfo[0].AutoRenewStatus == 1)
 }
 
+// CancelledAt is when App Store customer support refunded the purchase, or zero if it wasn't
 func (v validation) CancelledAt() time.Time {
 	if v.response.CancellationDate != nil {
 		return v.response.CancellationDate.Time()
 	}
-	return time.Time{}
+	return v.response.info.CancelledAt()
+}
+
+func (v validation) CancellationReason() (CancellationReason, bool) {
+	return v.response.info.CancellationReason()
 }
 
 func (v validation) ExpiresAt() time.Time {
 	return v.response.info.ExpiresAt()
 }
 
+func (v validation) IsInIntroOfferPeriod() bool {
+	return v.response.info.IsInIntroOfferPeriod()
+}
+
+func (v validation) IsInitialPurchase() bool {
+	return v.response.info.IsInitialPurchase()
+}
+
 func (v validation) IsTrialPeriod() bool {
 	return v.response.info.IsTrialPeriod()
 }
 
+func (v validation) IsUpgraded() bool {
+	return v.response.info.IsUpgraded()
+}
+
 func (v validation) OriginalTransactionID() string {
 	return v.response.info.OriginalTransactionID()
 }
@@ -124,6 +282,42 @@ func (v validation) ProductID() string {
 	return v.response.info.ProductID()
 }
 
+func (v validation) ProductType() ProductType {
+	return v.response.info.ProductType()
+}
+
+func (v validation) PromotionalOfferID() string {
+	return v.response.info.PromotionalOfferID()
+}
+
+func (v validation) Quantity() int {
+	return v.response.info.Quantity()
+}
+
+func (v validation) SubscriptionGroupID() string {
+	return v.response.info.SubscriptionGroupID()
+}
+
+func (v validation) TransactionID() string {
+	return v.response.info.TransactionID()
+}
+
+func (v validation) WebOrderLineItemID() string {
+	return v.response.info.WebOrderLineItemID()
+}
+
+// result bundles the latest transaction with the rest of the response
+func (v validation) result() VerifyResult {
+	return VerifyResult{
+		Info:               v,
+		Environment:        v.response.Environment,
+		LatestReceipt:      v.response.LatestReceipt,
+		PendingRenewalInfo: v.response.renewalInfo,
+		Receipt:            Receipt{v.response.appReceipt},
+		transactions:       v.response.transactions,
+	}
+}
+
 func (v validation) Status() int {
 	return v.response.Status
 }
@@ -134,33 +328,7 @@ func (v validation) HasError() bool {
 }
 
 func (v validation) Error() string {
-	r := v.response
-	switch r.Status {
-	case StatusUnreadable:
-		return "The App Store could not read the JSON object you provided."
-	case StatusReceiptMalformed:
-		return "The data in the receipt-data property was malformed or missing."
-	case StatusNotAuthenticated:
-		return "The receipt could not be authenticated."
-	case StatusMismatchedSecret:
-		return "The shared secret you provided does not match the shared secret on file for your account."
-	case StatusUnreachable:
-		return "The receipt server is not currently available."
-	case StatusSubscriptionExpired:
-		return "This receipt is valid but the subscription has expired."
-	case StatusReceiptFromTest:
-		return "This receipt is from the test environment, but it was sent to the production environment for verification. Send it to the test environment instead."
-	case StatusReceiptFromProd:
-		return "This receipt is from the production environment, but it was sent to the test environment for verification. Send it to the production environment instead."
-	default:
-		return ""
-	}
-}
-
-type renewalInfo struct {
-	AutoRenewStatus    int    `json:"auto_renew_status,string"`
-	AutoRenewProductID string `json:"auto_renew_product_id"`
-	ProductID          string `json:"product_id"`
+	return statusMessages[v.response.Status]
 }
 
 // These structs model the receipt data from Apple
@@ -176,14 +344,39 @@ type IOS6ReceiptInfo struct {
 	body ReceiptInfoBody
 }
 
+func (info IOS6ReceiptInfo) CancelledAt() time.Time {
+	if info.body.CancellationDate != nil {
+		return info.body.CancellationDate.Time()
+	}
+	return time.Time{}
+}
+
+func (info IOS6ReceiptInfo) CancellationReason() (CancellationReason, bool) {
+	return newCancellationReason(info.body.CancellationReason)
+}
+
 func (info IOS6ReceiptInfo) ExpiresAt() time.Time {
 	return info.body.ExpiresDate.Time()
 }
 
+// IsInIntroOfferPeriod is always false because iOS 6 style receipts predate introductory offers
+func (info IOS6ReceiptInfo) IsInIntroOfferPeriod() bool {
+	return false
+}
+
+func (info IOS6ReceiptInfo) IsInitialPurchase() bool {
+	return isInitialPurchase(info.body.TransactionID, info.body.OriginalTransactionID)
+}
+
 func (info IOS6ReceiptInfo) IsTrialPeriod() bool {
 	return info.body.IsTrialPeriod
 }
 
+// IsUpgraded is always false because iOS 6 style receipts predate subscription upgrades
+func (info IOS6ReceiptInfo) IsUpgraded() bool {
+	return false
+}
+
 func (info IOS6ReceiptInfo) OriginalPurchaseDate() time.Time {
 	return info.body.OriginalPurchaseDate.Time()
 }
@@ -200,18 +393,75 @@ func (info IOS6ReceiptInfo) ProductID() string {
 	return info.body.ProductID
 }
 
+func (info IOS6ReceiptInfo) ProductType() ProductType {
+	return productTypeOf(info.body.ExpiresDate)
+}
+
+// PromotionalOfferID is always empty because iOS 6 style receipts predate promotional offers
+func (info IOS6ReceiptInfo) PromotionalOfferID() string {
+	return ""
+}
+
+func (info IOS6ReceiptInfo) Quantity() int {
+	return parseQuantity(info.body.Quantity)
+}
+
+// SubscriptionGroupID is always empty because iOS 6 style receipts predate subscription groups
+func (info IOS6ReceiptInfo) SubscriptionGroupID() string {
+	return ""
+}
+
+func (info IOS6ReceiptInfo) TransactionID() string {
+	return info.body.TransactionID
+}
+
+// WebOrderLineItemID is empty for iOS 6 style receipts without one, like non-subscription purchases
+func (info IOS6ReceiptInfo) WebOrderLineItemID() string {
+	return info.body.WebOrderLineItemID
+}
+
+// isInitialPurchase reports whether a transaction is the original one rather than a renewal
+func isInitialPurchase(transactionID, originalTransactionID string) bool {
+	return transactionID != "" && transactionID == originalTransactionID
+}
+
 type modernReceiptInfo struct {
 	body ReceiptInfoBody
 }
 
+func (info modernReceiptInfo) CancelledAt() time.Time {
+	if info.body.CancellationDate != nil {
+		return info.body.CancellationDate.Time()
+	}
+	return time.Time{}
+}
+
+func (info modernReceiptInfo) CancellationReason() (CancellationReason, bool) {
+	return newCancellationReason(info.body.CancellationReason)
+}
+
 func (info modernReceiptInfo) ExpiresAt() time.Time {
 	return info.body.ExpiresDate.Time()
 }
 
+func (info modernReceiptInfo) IsInIntroOfferPeriod() bool {
+	return info.body.IsInIntroOfferPeriod
+}
+
+func (info modernReceiptInfo) IsInitialPurchase() bool {
+	return isInitialPurchase(info.body.TransactionID, info.body.OriginalTransactionID)
+}
+
 func (info modernReceiptInfo) IsTrialPeriod() bool {
 	return info.body.IsTrialPeriod
 }
 
+// IsUpgraded reports whether the customer upgraded to another subscription, which superseded
+// this transaction
+func (info modernReceiptInfo) IsUpgraded() bool {
+	return info.body.IsUpgraded
+}
+
 func (info modernReceiptInfo) OriginalPurchaseDate() time.Time {
 	return info.body.OriginalPurchaseDate.Time()
 }
@@ -228,133 +478,208 @@ func (info modernReceiptInfo) ProductID() string {
 	return info.body.ProductID
 }
 
-const (
-	sandboxURL    = "https://sandbox.itunes.apple.com/verifyReceipt"
-	productionURL = "https://buy.itunes.apple.com/verifyReceipt"
-)
+func (info modernReceiptInfo) ProductType() ProductType {
+	return productTypeOf(info.body.ExpiresDate)
+}
+
+func (info modernReceiptInfo) PromotionalOfferID() string {
+	return info.body.PromotionalOfferID
+}
 
-var fromTestEnvError = errors.New("Test receipt should be retrieved from prod endpoint")
+func (info modernReceiptInfo) Quantity() int {
+	return parseQuantity(info.body.Quantity)
+}
 
-func Validate(secret, receipt string) (Info, error) {
+// SubscriptionGroupID identifies the group of subscriptions the customer can only have one of
+func (info modernReceiptInfo) SubscriptionGroupID() string {
+	return info.body.SubscriptionGroupID
+}
 
-	if secret == "" {
-		return nil, errors.New("itunes.appSharedSecret should have been set")
-	}
+func (info modernReceiptInfo) TransactionID() string {
+	return info.body.TransactionID
+}
 
-	req := VerifyReceiptRequest{
-		ReceiptData:            receipt,
-		Password:               secret,
-		ExcludeOldTransactions: true,
+func (info modernReceiptInfo) WebOrderLineItemID() string {
+	return info.body.WebOrderLineItemID
+}
+
+// parseQuantity reads the quantity string, returning 1 for missing or malformed values since every
+// purchase is of at least one item
+func parseQuantity(quantity string) int {
+	n, err := strconv.Atoi(quantity)
+	if err != nil || n < 1 {
+		return 1
 	}
+	return n
+}
+
+const (
+	sandboxURL    = "https://sandbox.itunes.apple.com/verifyReceipt"
+	productionURL = "https://buy.itunes.apple.com/verifyReceipt"
+
+	// defaultUserAgent identifies this package to the App Store in place of Go's default
+	defaultUserAgent = "superscribe/1.x"
+)
 
-	buf := new(bytes.Buffer)
+// Environments the App Store reports having verified a receipt in
+const (
+	EnvironmentProduction = "Production"
+	EnvironmentSandbox    = "Sandbox"
+)
 
-	encoder := json.NewEncoder(buf)
-	if encodeErr := encoder.Encode(&req); encodeErr != nil {
-		log.Println("Should have encoded verifyReceipt request", receipt)
-		return nil, encodeErr
+func Validate(secret, receipt string) (Info, error) {
+	result, err := New(secret).Verify(receipt)
+	if err != nil {
+		return nil, err
 	}
+	return result, nil
+}
 
-	// Copy encoded data to a bytes.Reader to support multiple read passes
-	postData := bytes.NewReader(buf.Bytes())
+// sendReceiptRequest posts the receipt, keeping secret out of the HTTPError for an error page in
+// case a proxy echoes the request back
+func sendReceiptRequest(ctx context.Context, client *http.Client, verifyUrl string, header http.Header,
+	userAgent, secret string, postData io.Reader) ([]byte, error) {
 
-	client := http.Client{
-		Transport:     nil,              // Use default
-		CheckRedirect: nil,              // Use default
-		Jar:           nil,              // Don't care about cookies
-		Timeout:       time.Second * 20, // 20 second timeout
+	req, reqErr := http.NewRequest(http.MethodPost, verifyUrl, postData)
+	if reqErr != nil {
+		return nil, reqErr
 	}
-	// According to https://developer.apple.com/library/ios/technotes/tn2259/_index.html#//apple_ref/doc/uid/DTS40009578-CH1-ITUNES_CONNECT
-	// the correct way to verify is to try the prod verify url, and if that fails, then try the
-	// sandbox url.
-	data, sendErr := sendReceiptRequest(&client, productionURL, postData)
-	if sendErr != nil {
-		return nil, sendErr
+	for key, values := range header {
+		req.Header[key] = values
 	}
-
-	resp, parseErr := parseReceiptResponse(data)
-	if parseErr == fromTestEnvError {
-		if _, err := postData.Seek(0, io.SeekStart); err != nil {
-			return nil, err
-		}
-		data, sendErr = sendReceiptRequest(&client, sandboxURL, postData)
-		if sendErr != nil {
-			return nil, sendErr
-		}
-		resp, parseErr = parseReceiptResponse(data)
-		if parseErr != nil {
-			return nil, parseErr
-		}
-	} else if parseErr != nil {
-		return nil, parseErr
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	return resp, nil
-}
-
-func sendReceiptRequest(client *http.Client, verifyUrl string, postData io.Reader) ([]byte, error) {
 	// Send the receipt data to Apple for verification
-	verifyResp, responseErr := client.Post(verifyUrl, "application/json", postData)
+	verifyResp, responseErr := client.Do(req.WithContext(ctx))
 	if responseErr != nil {
 		return nil, responseErr
 	}
 
-	data, readErr := ioutil.ReadAll(verifyResp.Body)
 	defer verifyResp.Body.Close()
+
+	data, readErr := ioutil.ReadAll(verifyResp.Body)
 	if readErr != nil {
 		log.Println("Read to []byte", readErr)
 		return nil, readErr
 	}
 
+	if verifyResp.StatusCode < 200 || verifyResp.StatusCode > 299 {
+		// Redact before truncating so a secret cut off at the limit doesn't leave its prefix behind
+		if secret != "" {
+			data = bytes.Replace(data, []byte(secret), []byte("[redacted]"), -1)
+		}
+		if len(data) > maxHTTPErrorBody {
+			data = data[:maxHTTPErrorBody]
+		}
+		return nil, HTTPError{StatusCode: verifyResp.StatusCode, Body: string(data)}
+	}
+
 	return data, nil
 }
 
-func parseReceiptResponse(data []byte) (Info, error) {
+// ErrNoTransactions means the receipt is valid but has no transactions to describe, such as a
+// newly created receipt or one with only consumed purchases
+var ErrNoTransactions = errors.New("Receipt should have at least one transaction")
+
+// NoTransactionsError is ErrNoTransactions for a response whose receipt info lists are both empty,
+// which errors.Is matches with ErrNoTransactions
+type NoTransactionsError struct {
+	Status int
+}
+
+func (e NoTransactionsError) Error() string {
+	return fmt.Sprintf("Receipt with status %d should have at least one transaction", e.Status)
+}
+
+func (e NoTransactionsError) Is(target error) bool {
+	return target == ErrNoTransactions
+}
+
+// hasTransactions reports whether the receipt info is a non-empty list, or an iOS 6 style object
+func hasTransactions(receiptInfoData json.RawMessage) bool {
+	var list []json.RawMessage
+	if err := json.Unmarshal(receiptInfoData, &list); err == nil {
+		return len(list) > 0
+	}
+	return len(bytes.TrimSpace(receiptInfoData)) > 0
+}
+
+// appReceiptPurchases finds the in-app purchases of an iOS 7 style receipt object, which describes
+// the app rather than a transaction like iOS 6 style receipt info
+func appReceiptPurchases(receiptData json.RawMessage) (json.RawMessage, bool) {
+	var app struct {
+		BundleID json.RawMessage `json:"bundle_id"`
+		InApp    json.RawMessage `json:"in_app"`
+	}
+	if err := json.Unmarshal(receiptData, &app); err != nil || (app.BundleID == nil && app.InApp == nil) {
+		return nil, false
+	}
+	return app.InApp, true
+}
+
+func parseReceiptResponse(data []byte) (VerifyResult, error) {
+	return decodeReceiptResponse(data, false)
+}
+
+// decodeReceiptResponse is parseReceiptResponse, except that it fails on fields it doesn't know
+// when strict. iOS 6 style receipts mix app and transaction fields, so only their response and
+// pending renewal info get decoded strictly.
+func decodeReceiptResponse(data []byte, strict bool) (VerifyResult, error) {
 
 	var v validation
-	if err := json.Unmarshal(data, &v.response); err != nil {
+	if err := decodeJSON(data, &v.response, strict); err != nil {
 		log.Println("Should have parsed unknown-style Apple response", err)
-		return nil, err
+		return VerifyResult{}, err
 	}
 
-	switch v.Status() {
-	case StatusUnreadable, StatusUnreachable:
-		// TODO: Schedule a retry
-		return nil, fmt.Errorf(v.Error())
-	case StatusReceiptMalformed, StatusNotAuthenticated:
-		// TODO: Flag account with malformed or unauthenticated receipt for follow up
-		return nil, fmt.Errorf(v.Error())
-	case StatusMismatchedSecret:
-		return nil, fmt.Errorf("Tried to verify receipt with wrong password")
-	case StatusReceiptFromTest:
-		return nil, fromTestEnvError
+	if v.HasError() {
+		return VerifyResult{}, StatusError{v.Status()}
 	}
 
 	var receiptInfoData json.RawMessage
-	if v.Status() == StatusSubscriptionExpired || len(v.response.LatestExpiredReceiptInfo) > 0 {
+	switch {
+	case hasTransactions(v.response.LatestExpiredReceiptInfo):
 		receiptInfoData = v.response.LatestExpiredReceiptInfo
-	} else if len(v.response.LatestReceiptInfo) > 0 {
+	case hasTransactions(v.response.LatestReceiptInfo):
 		receiptInfoData = v.response.LatestReceiptInfo
-	} else {
+	case v.Status() == StatusSubscriptionExpired || len(v.response.LatestExpiredReceiptInfo) > 0 ||
+		len(v.response.LatestReceiptInfo) > 0 || !hasTransactions(v.response.Receipt):
+		// Brand new receipts may have neither list until the first renewal
+		return VerifyResult{}, NoTransactionsError{v.Status()}
+	default:
 		receiptInfoData = v.response.Receipt
+		if inApp, ok := appReceiptPurchases(v.response.Receipt); ok {
+			if !hasTransactions(inApp) {
+				return VerifyResult{}, NoTransactionsError{v.Status()}
+			}
+			receiptInfoData = inApp
+		}
 	}
 
 	var receiptInfo interface{}
 	if err := json.Unmarshal(receiptInfoData, &receiptInfo); err != nil {
 		log.Println("Should have decoded non/expired receipt", string(data))
-		return nil, err
+		return VerifyResult{}, err
 	}
+	_, iOS6 := receiptInfo.(map[string]interface{})
 
-	autoRenewStatus := v.AutoRenewStatus()
-
-	var pendingRenewalInfo []renewalInfo
 	if len(v.response.PendingRenewalInfo) > 0 {
-		if err := json.Unmarshal(v.response.PendingRenewalInfo, &pendingRenewalInfo); err != nil {
+		renewalInfo, err := decodeRenewalInfoList(v.response.PendingRenewalInfo, strict)
+		if err != nil {
 			log.Println("Should have decoded pending renewal info", err, string(data))
-			return nil, err
+			return VerifyResult{}, err
 		}
-		if len(pendingRenewalInfo) > 0 {
-			autoRenewStatus = autoRenewStatus || pendingRenewalInfo[0].AutoRenewStatus == 1
+		v.response.renewalInfo = renewalInfo
+	}
+
+	// iOS 7 style receipts describe the app in a receipt object, unlike older receipt lists
+	if len(v.response.Receipt) > 0 && v.response.Receipt[0] == '{' {
+		if err := v.response.appReceipt.decode(v.response.Receipt, strict && !iOS6); err != nil {
+			log.Println("Should have decoded app receipt", err, string(data))
+			return VerifyResult{}, err
 		}
 	}
 
@@ -363,25 +688,89 @@ func parseReceiptResponse(data []byte) (Info, error) {
 		var infoBody ReceiptInfoBody
 		if err := json.Unmarshal(receiptInfoData, &infoBody); err != nil {
 			log.Println("Should have decoded iOS 6 style receipt")
-			return nil, err
+			return VerifyResult{}, err
 		}
 
-		v.response.info = modernReceiptInfo{infoBody}
-		return v, nil
+		v.response.info = IOS6ReceiptInfo{infoBody}
+		v.response.transactions = []Transaction{v.response.info}
+		return v.result(), nil
 
 	case []interface{}:
-		var infoList []ReceiptInfoBody
-		if err := json.Unmarshal(receiptInfoData, &infoList); err != nil {
+		infoList, err := decodeReceiptInfoList(receiptInfoData, strict)
+		if err != nil {
 			log.Println("Should have decoded iOS 7+ style receipt")
-			return nil, err
+			return VerifyResult{}, err
 		}
-		sort.Slice(infoList, func(i, j int) bool {
-			return infoList[i].PurchaseDate.Time().Before(infoList[j].PurchaseDate.Time())
-		})
+		if len(infoList) == 0 {
+			return VerifyResult{}, NoTransactionsError{v.Status()}
+		}
+
+		infoList = dedupeReceiptInfo(infoList)
+		v.response.transactions = newTransactions(infoList)
+		v.response.info = v.response.transactions[len(infoList)-1]
+		return v.result(), nil
+	}
 
-		v.response.info = modernReceiptInfo{infoList[len(infoList)-1]}
-		return v, nil
+	// Neither an object nor a list, such as null or a string from a misbehaving endpoint
+	return VerifyResult{}, fmt.Errorf("Could not parse verifyReceipt response %d with receipt info %.32s",
+		v.Status(), receiptInfoData)
+}
+
+// dedupeReceiptInfo drops repeated entries for the same renewal, which the App Store sometimes
+// includes with old transactions, keeping whichever entry has the most fields set
+func dedupeReceiptInfo(infoList []ReceiptInfoBody) []ReceiptInfoBody {
+	seen := make(map[string]int, len(infoList))
+	deduped := infoList[:0]
+	for _, body := range infoList {
+		key := body.WebOrderLineItemID
+		if key == "" {
+			key = body.TransactionID
+		}
+		if i, ok := seen[key]; ok && key != "" {
+			if body.completeness() > deduped[i].completeness() {
+				deduped[i] = body
+			}
+			continue
+		}
+		seen[key] = len(deduped)
+		deduped = append(deduped, body)
+	}
+	return deduped
+}
+
+// completeness counts the fields set in the receipt info
+func (body ReceiptInfoBody) completeness() int {
+	n := 0
+	for _, set := range []bool{
+		body.Quantity != "",
+		body.ProductID != "",
+		body.TransactionID != "",
+		body.OriginalTransactionID != "",
+		body.PurchaseDate != 0,
+		body.OriginalPurchaseDate != 0,
+		body.CancellationDate != nil,
+		body.CancellationReason != nil,
+		body.PromotionalOfferID != "",
+		body.SubscriptionGroupID != "",
+		body.WebOrderLineItemID != "",
+		body.ExpiresDate != 0,
+	} {
+		if set {
+			n++
+		}
 	}
+	return n
+}
+
+// newTransactions sorts the receipt info by purchase date, so the latest transaction is last
+func newTransactions(infoList []ReceiptInfoBody) []Transaction {
+	sort.Slice(infoList, func(i, j int) bool {
+		return infoList[i].PurchaseDate.Time().Before(infoList[j].PurchaseDate.Time())
+	})
 
-	return nil, fmt.Errorf("Could not parse verifyReceipt response %d\n", v.Status())
+	transactions := make([]Transaction, len(infoList))
+	for i, body := range infoList {
+		transactions[i] = modernReceiptInfo{body}
+	}
+	return transactions
 }
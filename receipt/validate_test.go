@@ -2,7 +2,12 @@ package receipt
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -46,6 +51,10 @@ func TestParseResponse1(t *testing.T) {
 	if resp.Status() != StatusValid {
 		t.Error("Should parse status as valid")
 	}
+
+	if resp.Environment != "" {
+		t.Errorf("Should leave missing environment empty, not %q", resp.Environment)
+	}
 }
 
 func TestParseResponse2(t *testing.T) {
@@ -64,6 +73,10 @@ func TestParseResponse2(t *testing.T) {
 		t.Errorf("Should parse %s as %s", resp.ExpiresAt(), expiresAt)
 	}
 
+	if resp.LatestReceipt != "latestreceipt==" {
+		t.Errorf("Should parse latest receipt, not %q", resp.LatestReceipt)
+	}
+
 	if resp.Status() != StatusValid {
 		t.Error("Should parse status as valid")
 	}
@@ -114,4 +127,1369 @@ func TestParseResponse4(t *testing.T) {
 	if resp.Status() != StatusValid {
 		t.Error("Should parse status as 0 Valid")
 	}
+
+	if resp.Environment != EnvironmentSandbox {
+		t.Errorf("Should parse environment as Sandbox, not %q", resp.Environment)
+	}
+}
+
+func TestParseResponse5(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response5.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if resp.TransactionID() != "123456789012347" {
+		t.Errorf("Should parse latest transaction ID, not %s", resp.TransactionID())
+	}
+
+	if resp.AutoRenewStatus() {
+		t.Error("Should parse auto renew status as off")
+	}
+
+	if renewals := resp.PendingRenewalInfo; len(renewals) != 1 || renewals[0].ExpirationIntent != 1 {
+		t.Errorf("Should parse pending renewal expiration intent 1, not %v", renewals)
+	}
+
+	if intent, ok := resp.ExpirationIntent(); !ok || intent != ExpirationIntentCancelled || !intent.Voluntary() {
+		t.Errorf("Should explain expiration as voluntary cancellation, not %d", intent)
+	}
+
+	if resp.Quantity() != 1 {
+		t.Errorf("Should parse quantity as 1, not %d", resp.Quantity())
+	}
+
+	originalPurchaseDate := time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)
+	if !resp.OriginalPurchaseDate().Equal(originalPurchaseDate) {
+		t.Errorf("Should parse original purchase date %s as %s", resp.OriginalPurchaseDate(), originalPurchaseDate)
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	cases := map[string]int{
+		"1":   1,
+		"3":   3,
+		"25":  25,
+		"":    1,
+		"0":   1,
+		"two": 1,
+	}
+	for quantity, expected := range cases {
+		if actual := parseQuantity(quantity); actual != expected {
+			t.Errorf("Should parse quantity %q as %d, not %d", quantity, expected, actual)
+		}
+	}
+}
+
+func TestParsePendingRenewalInfo(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response1.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if !resp.AutoRenewStatus() {
+		t.Error("Should parse auto renew status as on")
+	}
+
+	if intent, ok := resp.ExpirationIntent(); ok {
+		t.Errorf("Should not explain expiration of an active subscription, not %d", intent)
+	}
+
+	renewals := resp.PendingRenewalInfo
+	if len(renewals) != 1 {
+		t.Fatalf("Should parse 1 pending renewal, not %d", len(renewals))
+	}
+	if renewals[0].AutoRenewProductID != "year-premium" {
+		t.Errorf("Should parse auto renew product ID, not %s", renewals[0].AutoRenewProductID)
+	}
+	if renewals[0].OriginalTransactionID != "123456789012345" {
+		t.Errorf("Should parse original transaction ID, not %s", renewals[0].OriginalTransactionID)
+	}
+}
+
+func TestParseTransactionCancellation(t *testing.T) {
+	data := []byte(`{
+		"status": 0,
+		"latest_receipt_info": [
+			{
+				"product_id": "month-premium",
+				"original_transaction_id": "123456789012345",
+				"purchase_date_ms": "1551398400000",
+				"expires_date_ms": "1554076800000",
+				"cancellation_date_ms": "1551657600000"
+			}
+		]
+	}`)
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	cancelledAt := time.Date(2019, time.March, 4, 0, 0, 0, 0, time.UTC)
+	if !cancelledAt.Equal(resp.CancelledAt()) {
+		t.Errorf("Should parse transaction cancellation %s as %s", resp.CancelledAt(), cancelledAt)
+	}
+}
+
+func TestParseNoCancellation(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response5.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if !resp.CancelledAt().IsZero() {
+		t.Errorf("Should not have cancelled at %s", resp.CancelledAt())
+	}
+}
+
+func TestParseStatusErrors(t *testing.T) {
+	cases := map[int]error{
+		StatusUnreadable:       ErrUnreadable,
+		StatusReceiptMalformed: ErrReceiptMalformed,
+		StatusNotAuthenticated: ErrNotAuthenticated,
+		StatusMismatchedSecret: ErrMismatchedSecret,
+		StatusUnreachable:      ErrUnreachable,
+		StatusReceiptFromTest:  ErrReceiptFromTest,
+		StatusReceiptFromProd:  ErrReceiptFromProd,
+		StatusUnauthorized:     ErrReceiptUnauthorized,
+	}
+	for status, expected := range cases {
+		data := []byte(fmt.Sprintf(`{"status":%d}`, status))
+		if _, err := parseReceiptResponse(data); !errors.Is(err, expected) {
+			t.Errorf("Should return %v for status %d, not %v", expected, status, err)
+		}
+	}
+}
+
+func TestParseUnauthorizedReceipt(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response11.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	_, err := parseReceiptResponse(data)
+	if !errors.Is(err, ErrReceiptUnauthorized) {
+		t.Fatalf("Should report unauthorized receipt, not %v", err)
+	}
+	if err.(StatusError).Temporary() {
+		t.Error("Should not retry an unauthorized receipt")
+	}
+	if err.Error() != statusMessages[StatusUnauthorized] {
+		t.Errorf("Should describe unauthorized receipt, not %q", err.Error())
+	}
+}
+
+func TestDecodeStrictResponse(t *testing.T) {
+	fixtures, globErr := filepath.Glob("testdata/response*.json")
+	if globErr != nil {
+		t.Fatal(globErr)
+	}
+	for _, fixture := range fixtures {
+		data, readErr := ioutil.ReadFile(fixture)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+
+		// Fixtures with error statuses fail the same way either way
+		_, lenientErr := decodeReceiptResponse(data, false)
+		if _, err := decodeReceiptResponse(data, true); fmt.Sprint(err) != fmt.Sprint(lenientErr) {
+			t.Errorf("Should strictly decode %s, not %v", fixture, err)
+		}
+	}
+
+	data, readErr := ioutil.ReadFile("testdata/response16.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	resp, err := decodeReceiptResponse(data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestedAt := time.Date(2021, time.May, 15, 0, 0, 5, 0, time.UTC)
+	if resp.Receipt.body.AdamID != 1234567890 || !resp.Receipt.body.RequestDate.Time().Equal(requestedAt) {
+		t.Errorf("Should decode the documented receipt fields, not %+v", resp.Receipt.body)
+	}
+	if info := resp.Info.(validation).response.info.(modernReceiptInfo).body; info.OfferCodeRefName != "spring" ||
+		info.InAppOwnershipType != OwnershipPurchased {
+		t.Errorf("Should decode the documented receipt info fields, not %+v", info)
+	}
+	graceEndsAt := time.Date(2021, time.June, 8, 0, 0, 0, 0, time.UTC)
+	if expiresAt, ok := resp.GracePeriodExpiresAt(); !ok || !expiresAt.Equal(graceEndsAt) {
+		t.Errorf("Should decode the documented renewal info fields, not %s", expiresAt)
+	}
+
+	for name, data := range map[string]string{
+		"response":             `{"status":0,"is_retryable":false,"latest_receipt_info":[{"product_id":"month-premium"}]}`,
+		"latest receipt info":  `{"status":0,"latest_receipt_info":[{"product_id":"month-premium","offer_code":"spring"}]}`,
+		"pending renewal info": `{"status":0,"latest_receipt_info":[{}],"pending_renewal_info":[{"offer_code":"spring"}]}`,
+		"receipt":              `{"status":0,"latest_receipt_info":[{}],"receipt":{"app_version":"42"}}`,
+		"in-app purchase":      `{"status":0,"latest_receipt_info":[{}],"receipt":{"in_app":[{"account_token":"abc"}]}}`,
+	} {
+		if _, err := decodeReceiptResponse([]byte(data), true); err == nil {
+			t.Errorf("Should reject unknown field in %s", name)
+		}
+		if _, err := decodeReceiptResponse([]byte(data), false); err != nil {
+			t.Errorf("Should ignore unknown field in %s when lenient, not %v", name, err)
+		}
+	}
+}
+
+func TestStatusErrorMessage(t *testing.T) {
+	if ErrMismatchedSecret.Error() != statusMessages[StatusMismatchedSecret] {
+		t.Errorf("Should describe mismatched secret, not %q", ErrMismatchedSecret.Error())
+	}
+	if msg := (StatusError{21099}).Error(); msg != "The App Store returned status 21099." {
+		t.Errorf("Should describe unknown status, not %q", msg)
+	}
+}
+
+func TestIsActive(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response5.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if !resp.IsActive(time.Date(2019, time.April, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Should be active before expiring")
+	}
+	if resp.IsActive(time.Date(2019, time.May, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Should not be active once expired")
+	}
+
+	if !MockResult(ReceiptInfoBody{ProductID: "lifetime-stickers"}).IsActive(time.Now()) {
+		t.Error("Should be active without an expiry, like a SignedTransaction")
+	}
+	if (VerifyResult{}).IsActive(time.Now()) {
+		t.Error("Should not be active without Info")
+	}
+}
+
+func TestIsActiveWithoutPurchases(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response17.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	// A fresh install's receipt describes only the app, which doesn't expire
+	resp, _ := parseReceiptResponse(data)
+	now := time.Now()
+	if resp.IsActive(now) || resp.State(now) == SubscriptionActive {
+		t.Error("Should not grant access for a receipt without purchases")
+	}
+}
+
+func TestExpiresSoon(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+
+	for _, test := range []struct {
+		name      string
+		expiresAt time.Time
+		soon      bool
+	}{
+		{"within the window", now.Add(3 * 24 * time.Hour), true},
+		{"after the window", now.Add(2 * week), false},
+		{"already expired", now.Add(-time.Hour), false},
+		{"expiring now", now, false},
+		{"never expiring", time.Time{}, false},
+	} {
+		result := MockResult(ReceiptInfoBody{ExpiresDate: newMillistamp(test.expiresAt)})
+		if soon := result.ExpiresSoon(week, now); soon != test.soon {
+			t.Errorf("%s: Should expire soon %v, not %v", test.name, test.soon, soon)
+		}
+	}
+
+	cancellationDate := newMillistamp(now.Add(-time.Hour))
+	refunded := MockResult(ReceiptInfoBody{
+		ExpiresDate:      newMillistamp(now.Add(24 * time.Hour)),
+		CancellationDate: &cancellationDate,
+	})
+	if refunded.ExpiresSoon(week, now) {
+		t.Error("Should not remind a refunded subscription to renew")
+	}
+	if (VerifyResult{}).ExpiresSoon(week, now) {
+		t.Error("Should not expire soon without Info")
+	}
+}
+
+func TestDaysUntilExpiry(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response5.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	for _, test := range []struct {
+		now  time.Time
+		days int
+	}{
+		{time.Date(2019, time.April, 15, 0, 0, 0, 0, time.UTC), 16},
+		{time.Date(2019, time.April, 29, 12, 0, 0, 0, time.UTC), 1},
+		{time.Date(2019, time.April, 30, 12, 0, 0, 0, time.UTC), 0},
+		{time.Date(2019, time.May, 1, 12, 0, 0, 0, time.UTC), -1},
+		{time.Date(2019, time.May, 3, 0, 0, 0, 0, time.UTC), -2},
+	} {
+		if days, ok := resp.DaysUntilExpiry(test.now); !ok || days != test.days {
+			t.Errorf("Should have %d days left at %s, not %d", test.days, test.now, days)
+		}
+	}
+
+	if days, ok := MockResult(ReceiptInfoBody{}).DaysUntilExpiry(time.Now()); ok {
+		t.Errorf("Should not count days for a purchase that never expires, not %d", days)
+	}
+	if days, ok := (VerifyResult{}).DaysUntilExpiry(time.Now()); ok {
+		t.Errorf("Should not count days without Info, not %d", days)
+	}
+}
+
+func TestState(t *testing.T) {
+	now := time.Date(2019, time.April, 15, 0, 0, 0, 0, time.UTC)
+	before, after := newMillistamp(now.AddDate(0, 0, -1)), newMillistamp(now.AddDate(0, 0, 1))
+
+	for _, test := range []struct {
+		result VerifyResult
+		state  SubscriptionState
+	}{
+		{MockResult(ReceiptInfoBody{ExpiresDate: after}), SubscriptionActive},
+		{MockResult(ReceiptInfoBody{ExpiresDate: after, IsTrialPeriod: true}), SubscriptionTrial},
+		{MockResult(ReceiptInfoBody{ExpiresDate: before},
+			PendingRenewalInfo{GracePeriodExpiresDate: &after}), SubscriptionInGracePeriod},
+		{MockResult(ReceiptInfoBody{ExpiresDate: before},
+			PendingRenewalInfo{ExpirationIntent: ExpirationIntentCancelled}), SubscriptionExpiredVoluntarily},
+		{MockResult(ReceiptInfoBody{ExpiresDate: before},
+			PendingRenewalInfo{ExpirationIntent: ExpirationIntentBillingError}), SubscriptionExpiredInvoluntarily},
+		{MockResult(ReceiptInfoBody{ExpiresDate: before}), SubscriptionExpiredInvoluntarily},
+		{MockResult(ReceiptInfoBody{ExpiresDate: before, IsTrialPeriod: true},
+			PendingRenewalInfo{GracePeriodExpiresDate: &before}), SubscriptionExpiredInvoluntarily},
+		{MockResult(ReceiptInfoBody{ExpiresDate: after, IsTrialPeriod: true, CancellationDate: &before}),
+			SubscriptionRefunded},
+		{MockResult(ReceiptInfoBody{ProductID: "lifetime-stickers"}), SubscriptionActive},
+		{VerifyResult{}, SubscriptionUnknown},
+	} {
+		if state := test.result.State(now); state != test.state {
+			t.Errorf("Should be in state %d, not %d", test.state, state)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	prevAt := time.Date(2019, time.April, 15, 0, 0, 0, 0, time.UTC)
+	currAt := prevAt.AddDate(0, 0, 7)
+	expiresAt := newMillistamp(prevAt.AddDate(0, 0, 1))
+	renewedAt := newMillistamp(currAt.AddDate(0, 1, 0))
+	graceEndsAt := newMillistamp(currAt.AddDate(0, 0, 1))
+
+	prev := MockResult(ReceiptInfoBody{TransactionID: "1", ExpiresDate: expiresAt})
+
+	for _, test := range []struct {
+		name  string
+		curr  VerifyResult
+		event SubscriptionEvent
+	}{
+		{"unchanged", MockResult(ReceiptInfoBody{TransactionID: "1", ExpiresDate: renewedAt}), EventNone},
+		{"renewed", MockResult(ReceiptInfoBody{TransactionID: "2", ExpiresDate: renewedAt}), EventRenewed},
+		{"grace", MockResult(ReceiptInfoBody{TransactionID: "1", ExpiresDate: expiresAt},
+			PendingRenewalInfo{GracePeriodExpiresDate: &graceEndsAt}), EventEnteredGracePeriod},
+		{"expired", MockResult(ReceiptInfoBody{TransactionID: "1", ExpiresDate: expiresAt}), EventExpired},
+		{"refunded", MockResult(ReceiptInfoBody{TransactionID: "1", ExpiresDate: expiresAt,
+			CancellationDate: &expiresAt}), EventRefunded},
+	} {
+		if event := Transition(prev, prevAt, test.curr, currAt); event != test.event {
+			t.Errorf("Should report %s as event %d, not %d", test.name, test.event, event)
+		}
+	}
+
+	grace := MockResult(ReceiptInfoBody{TransactionID: "1", ExpiresDate: expiresAt},
+		PendingRenewalInfo{GracePeriodExpiresDate: &graceEndsAt})
+	if event := Transition(grace, currAt, prev, currAt.AddDate(0, 0, 2)); event != EventExpired {
+		t.Errorf("Should report expiring after the grace period, not %d", event)
+	}
+
+	if event := Transition(VerifyResult{}, prevAt, prev, currAt); event != EventNone {
+		t.Errorf("Should not report an event on the first check, not %d", event)
+	}
+	if event := Transition(prev, prevAt, VerifyResult{}, currAt); event != EventNone {
+		t.Errorf("Should not report an event without Info, not %d", event)
+	}
+}
+
+func TestTransitionUpgraded(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response7.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	curr, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	prev := MockResult(ReceiptInfoBody{TransactionID: "323456789012345", ProductID: "month-premium"})
+	now := curr.PaidAt()
+	if event := Transition(prev, now, curr, now); event != EventUpgraded {
+		t.Errorf("Should report the upgrade, not %d", event)
+	}
+}
+
+func TestIsActiveAfterRefund(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response3.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if resp.IsActive(time.Date(2018, time.April, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Should not be active after a refund")
+	}
+}
+
+func TestIsInGracePeriod(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response6.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	inGrace := time.Date(2019, time.April, 3, 0, 0, 0, 0, time.UTC)
+	if !resp.IsInGracePeriod(inGrace) || !resp.IsActive(inGrace) {
+		t.Error("Should be active during the grace period")
+	}
+
+	afterGrace := time.Date(2019, time.April, 9, 0, 0, 0, 0, time.UTC)
+	if resp.IsInGracePeriod(afterGrace) || resp.IsActive(afterGrace) {
+		t.Error("Should not be active after the grace period")
+	}
+
+	graceEndsAt := time.Date(2019, time.April, 8, 0, 0, 0, 0, time.UTC)
+	if expiresAt, ok := resp.GracePeriodExpiresAt(); !ok || !expiresAt.Equal(graceEndsAt) {
+		t.Errorf("Should parse grace period expiry %s, not %s", graceEndsAt, expiresAt)
+	}
+	if !resp.IsInGracePeriod(graceEndsAt.Add(-time.Millisecond)) || resp.IsInGracePeriod(graceEndsAt) {
+		t.Error("Should end the grace period at the exact millisecond")
+	}
+
+	for _, info := range []PendingRenewalInfo{{}, {GracePeriodExpiresDate: new(Millistamp)}} {
+		absent := MockResult(ReceiptInfoBody{}, info)
+		if _, ok := absent.GracePeriodExpiresAt(); ok || absent.IsInGracePeriod(inGrace) {
+			t.Errorf("Should not be in a grace period without its expiry, not %v", info.GracePeriodExpiresDate)
+		}
+	}
+	if _, ok := MockResult(ReceiptInfoBody{}).GracePeriodExpiresAt(); ok {
+		t.Error("Should not be in a grace period without pending renewal info")
+	}
+}
+
+func TestParseOfferFields(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response5.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if !resp.IsInIntroOfferPeriod() {
+		t.Error("Should parse intro offer period")
+	}
+	if resp.PromotionalOfferID() != "spring-promo" {
+		t.Errorf("Should parse promotional offer ID, not %q", resp.PromotionalOfferID())
+	}
+
+	ios6 := IOS6ReceiptInfo{ReceiptInfoBody{IsInIntroOfferPeriod: true, PromotionalOfferID: "spring-promo"}}
+	if ios6.IsInIntroOfferPeriod() || ios6.PromotionalOfferID() != "" {
+		t.Error("Should ignore offer fields on iOS 6 style receipts")
+	}
+}
+
+func TestParseExpiredReceiptInfoShapes(t *testing.T) {
+	for _, test := range []struct {
+		fixture       string
+		ios6          bool
+		transactionID string
+		transactions  int
+	}{
+		{"response3.json", true, "123456789012345", 1},
+		{"response14.json", false, "423456789012346", 2},
+	} {
+		data, readErr := ioutil.ReadFile("testdata/" + test.fixture)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+
+		resp, parseErr := parseReceiptResponse(data)
+		if parseErr != nil {
+			t.Fatal(parseErr)
+		}
+
+		if _, ios6 := resp.Info.(validation).response.info.(IOS6ReceiptInfo); ios6 != test.ios6 {
+			t.Errorf("Should decode expired receipt info in %s as iOS 6 style %t", test.fixture, test.ios6)
+		}
+		if resp.TransactionID() != test.transactionID || len(resp.AllTransactions()) != test.transactions {
+			t.Errorf("Should parse %d expired transactions ending with %s in %s, not %d ending with %s",
+				test.transactions, test.transactionID, test.fixture, len(resp.AllTransactions()), resp.TransactionID())
+		}
+		if resp.Status() != StatusSubscriptionExpired || resp.IsActive(resp.ExpiresAt()) {
+			t.Errorf("Should parse %s as expired", test.fixture)
+		}
+	}
+}
+
+func TestParseInternalDataAccessError(t *testing.T) {
+	_, err := parseReceiptResponse([]byte(`{"status":21150}`))
+
+	if !errors.Is(err, ErrInternalDataAccess) {
+		t.Errorf("Should match internal data access error, not %v", err)
+	}
+
+	if statusErr, ok := err.(StatusError); !ok || !statusErr.Temporary() {
+		t.Errorf("Should treat internal data access error as temporary, not %v", err)
+	}
+
+	if errors.Is(ErrReceiptMalformed, ErrInternalDataAccess) {
+		t.Error("Should not match internal data access error for a malformed receipt")
+	}
+}
+
+func TestParseAppReceipt(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response4.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	app := resp.Receipt
+	if app.BundleID() != "com.example.superscribe" {
+		t.Errorf("Should parse bundle ID, not %q", app.BundleID())
+	}
+	if app.ApplicationVersion() != "42" {
+		t.Errorf("Should parse application version, not %q", app.ApplicationVersion())
+	}
+	if app.AppItemID() != 1234567890 {
+		t.Errorf("Should parse app item ID, not %d", app.AppItemID())
+	}
+}
+
+func TestParseIOS6AppReceipt(t *testing.T) {
+	data := []byte(`{"status":0,"receipt":{"bid":"com.example.superscribe","bvrs":"1.2",
+		"product_id":"year-premium","purchase_date_ms":"1534739337000"}}`)
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	app := resp.Receipt
+	if app.BundleID() != "com.example.superscribe" || app.ApplicationVersion() != "1.2" {
+		t.Errorf("Should parse iOS 6 bundle ID and version, not %q %q", app.BundleID(),
+			app.ApplicationVersion())
+	}
+}
+
+func TestParseAllTransactions(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response5.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	transactions := resp.AllTransactions()
+	expected := []string{"123456789012345", "123456789012346", "123456789012347"}
+	if len(transactions) != len(expected) {
+		t.Fatalf("Should parse %d transactions, not %d", len(expected), len(transactions))
+	}
+	for i, transaction := range transactions {
+		if transaction.TransactionID() != expected[i] {
+			t.Errorf("Should sort transaction %d as %s, not %s", i, expected[i], transaction.TransactionID())
+		}
+	}
+
+	if transactions[len(transactions)-1].TransactionID() != resp.TransactionID() {
+		t.Error("Should end with the latest transaction")
+	}
+}
+
+func TestForEachTransaction(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response5.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	var visited []string
+	if err := resp.ForEachTransaction(func(transaction Transaction) error {
+		visited = append(visited, transaction.TransactionID())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(visited) != "[123456789012345 123456789012346 123456789012347]" {
+		t.Errorf("Should visit every transaction in order, not %v", visited)
+	}
+
+	errFound := errors.New("found")
+	visited = nil
+	err := resp.ForEachTransaction(func(transaction Transaction) error {
+		visited = append(visited, transaction.TransactionID())
+		if !transaction.IsTrialPeriod() {
+			return errFound
+		}
+		return nil
+	})
+	if err != errFound || len(visited) != 2 {
+		t.Errorf("Should stop at the first error, not %v after %v", err, visited)
+	}
+}
+
+func TestParseDuplicateTransactions(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response12.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	transactions := resp.AllTransactions()
+	expected := []string{"123456789012345", "123456789012346", "123456789012347"}
+	if len(transactions) != len(expected) {
+		t.Fatalf("Should dedupe to %d transactions, not %d", len(expected), len(transactions))
+	}
+	for i, transaction := range transactions {
+		if transaction.TransactionID() != expected[i] {
+			t.Errorf("Should sort transaction %d as %s, not %s", i, expected[i], transaction.TransactionID())
+		}
+		if transaction.SubscriptionGroupID() != "20512345" {
+			t.Errorf("Should keep the most complete entry for %s", transaction.TransactionID())
+		}
+	}
+}
+
+func TestRenewalCount(t *testing.T) {
+	for fixture, expected := range map[string]int{
+		"testdata/response5.json":  2,
+		"testdata/response12.json": 2,
+		"testdata/response7.json":  1,
+	} {
+		data, readErr := ioutil.ReadFile(fixture)
+		if readErr != nil {
+			t.Error(readErr)
+		}
+
+		resp, parseErr := parseReceiptResponse(data)
+		if parseErr != nil {
+			t.Fatal(parseErr)
+		}
+		if count := resp.RenewalCount(); count != expected {
+			t.Errorf("Should count %d renewals in %s, not %d", expected, fixture, count)
+		}
+	}
+
+	if count := MockResult(ReceiptInfoBody{TransactionID: "1", OriginalTransactionID: "1"}).RenewalCount(); count != 0 {
+		t.Errorf("Should not count the original purchase, not %d", count)
+	}
+}
+
+func TestRenewalTimeline(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response5.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	timeline := resp.RenewalTimeline()
+	if len(timeline) != 3 {
+		t.Fatalf("Should list 3 periods, not %d", len(timeline))
+	}
+	if !timeline[0].IsTrial || timeline[1].IsTrial || timeline[2].IsTrial {
+		t.Error("Should start with the trial period")
+	}
+	for i, period := range timeline {
+		if period.ProductID != "month-premium" || !period.End.After(period.Start) {
+			t.Errorf("Should describe period %d, not %v", i, period)
+		}
+		if i > 0 && !period.Start.Equal(timeline[i-1].End) {
+			t.Errorf("Should renew period %d when the last one ended, not %s", i, period.Start)
+		}
+	}
+}
+
+func TestParseUpgradedTransaction(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response7.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if resp.IsUpgraded() || resp.ProductID() != "year-premium" {
+		t.Errorf("Should parse latest transaction as the upgrade, not %s", resp.ProductID())
+	}
+
+	transactions := resp.AllTransactions()
+	if len(transactions) != 2 || !transactions[0].IsUpgraded() {
+		t.Error("Should parse superseded month-premium transaction as upgraded")
+	}
+}
+
+func TestWillAutoRenew(t *testing.T) {
+	result := MockResult(ReceiptInfoBody{OriginalTransactionID: "223456789012345"},
+		PendingRenewalInfo{
+			AutoRenewStatus:       1,
+			AutoRenewProductID:    "month-basic",
+			OriginalTransactionID: "123456789012345",
+		},
+		PendingRenewalInfo{
+			AutoRenewStatus:       0,
+			AutoRenewProductID:    "year-premium",
+			OriginalTransactionID: "223456789012345",
+		})
+
+	if result.WillAutoRenew() {
+		t.Error("Should match the cancelled renewal of the same subscription")
+	}
+	if product := result.NextRenewalProductID(); product != "year-premium" {
+		t.Errorf("Should renew to year-premium, not %q", product)
+	}
+
+	if MockResult(ReceiptInfoBody{}).WillAutoRenew() {
+		t.Error("Should not renew without pending renewal info")
+	}
+}
+
+func TestIsInBillingRetry(t *testing.T) {
+	data := []byte(`{
+		"status": 0,
+		"latest_receipt_info": [{"original_transaction_id": "123456789012345"}],
+		"pending_renewal_info": [{
+			"original_transaction_id": "123456789012345",
+			"is_in_billing_retry_period": "1"
+		}]
+	}`)
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	if !resp.IsInBillingRetry() {
+		t.Error("Should decode billing retry period")
+	}
+
+	if MockResult(ReceiptInfoBody{}).IsInBillingRetry() {
+		t.Error("Should not be in billing retry without pending renewal info")
+	}
+	if MockResult(ReceiptInfoBody{}, PendingRenewalInfo{}).IsInBillingRetry() {
+		t.Error("Should not be in billing retry when the field is absent")
+	}
+}
+
+func TestParseEmptyLatestReceiptInfo(t *testing.T) {
+	data := []byte(`{"status":0,"latest_receipt_info":[]}`)
+
+	if _, err := parseReceiptResponse(data); !errors.Is(err, ErrNoTransactions) {
+		t.Errorf("Should fail without transactions, not %v", err)
+	}
+}
+
+func TestParseWithoutTransactions(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response13.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	_, err := parseReceiptResponse(data)
+	if !errors.Is(err, ErrNoTransactions) {
+		t.Fatalf("Should fail without transactions, not %v", err)
+	}
+	if err.Error() != "Receipt with status 21006 should have at least one transaction" {
+		t.Errorf("Should mention the status, not %q", err.Error())
+	}
+
+	if _, err := parseReceiptResponse([]byte(`{"status":0}`)); !errors.Is(err, ErrNoTransactions) {
+		t.Errorf("Should fail without receipt info, not %v", err)
+	}
+}
+
+func TestParseAppReceiptWithoutPurchases(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response17.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	// The receipt object describes the app, not a transaction
+	if result, err := parseReceiptResponse(data); !errors.Is(err, ErrNoTransactions) {
+		t.Errorf("Should fail without in-app purchases, not %v with %v", err, result.AllTransactions())
+	}
+}
+
+func TestOriginalPurchaseDateForBothReceiptStyles(t *testing.T) {
+	originalPurchaseDate := time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)
+	body := ReceiptInfoBody{
+		OriginalPurchaseDate: Millistamp(originalPurchaseDate.UnixNano() / int64(time.Millisecond)),
+		PurchaseDate:         Millistamp(originalPurchaseDate.AddDate(0, 2, 0).UnixNano() / int64(time.Millisecond)),
+	}
+
+	for _, info := range []Transaction{IOS6ReceiptInfo{body}, modernReceiptInfo{body}} {
+		if !info.OriginalPurchaseDate().Equal(originalPurchaseDate) {
+			t.Errorf("%T should parse original purchase date %s as %s", info, info.OriginalPurchaseDate(), originalPurchaseDate)
+		}
+		if info.OriginalPurchaseDate().Equal(info.PaidAt()) {
+			t.Errorf("%T should tell the original purchase from the renewal", info)
+		}
+	}
+}
+
+func TestParseSubscriptionGroupID(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response5.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if resp.SubscriptionGroupID() != "20512345" {
+		t.Errorf("Should parse subscription group ID, not %q", resp.SubscriptionGroupID())
+	}
+
+	ios6 := IOS6ReceiptInfo{ReceiptInfoBody{SubscriptionGroupID: "20512345"}}
+	if ios6.SubscriptionGroupID() != "" {
+		t.Error("Should ignore subscription group ID on iOS 6 style receipts")
+	}
+}
+
+func TestLatestActiveTransaction(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response8.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	transaction, ok := resp.LatestActiveTransaction("month-premium")
+	if !ok || transaction.TransactionID() != "423456789012346" {
+		t.Errorf("Should skip the refunded renewal for the one before it, not %v", transaction)
+	}
+
+	if _, ok := resp.LatestActiveTransaction("year-premium"); ok {
+		t.Error("Should not find a transaction for a product never purchased")
+	}
+}
+
+func TestEntitlementsByProduct(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response8.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	now := time.Date(2019, time.March, 15, 0, 0, 0, 0, time.UTC)
+	entitlements := resp.EntitlementsByProduct(now)
+	if len(entitlements) != 2 {
+		t.Errorf("Should have an entitlement for each product, not %v", entitlements)
+	}
+	if transaction := entitlements["month-premium"]; transaction == nil || transaction.TransactionID() != "423456789012346" {
+		t.Errorf("Should skip the refunded renewal for the one before it, not %v", transaction)
+	}
+	if transaction := entitlements["lifetime-stickers"]; transaction == nil || transaction.TransactionID() != "423456789012348" {
+		t.Errorf("Should include the lifetime purchase, not %v", transaction)
+	}
+
+	expired := resp.EntitlementsByProduct(now.AddDate(0, 1, 0))
+	if len(expired) != 1 || expired["lifetime-stickers"] == nil {
+		t.Errorf("Should leave out the expired subscription, not %v", expired)
+	}
+}
+
+func TestEntitlementsByProductGracePeriod(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response6.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	expiresAt := resp.ExpiresAt()
+	if transaction := resp.EntitlementsByProduct(expiresAt.Add(time.Hour))["month-premium"]; transaction == nil {
+		t.Error("Should keep the subscription entitled during its grace period")
+	}
+	if entitlements := resp.EntitlementsByProduct(expiresAt.AddDate(0, 0, 7)); len(entitlements) != 0 {
+		t.Errorf("Should leave out the subscription after its grace period, not %v", entitlements)
+	}
+}
+
+func TestInAppPurchases(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response9.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if len(resp.AllTransactions()) != 1 {
+		t.Errorf("Should only have the subscription in latest receipt info, not %d", len(resp.AllTransactions()))
+	}
+
+	purchases := resp.InAppPurchases()
+	if len(purchases) != 2 {
+		t.Fatalf("Should have 2 in-app purchases, not %d", len(purchases))
+	}
+	if purchases[0].ProductID() != "coins-100" || purchases[0].Quantity() != 5 {
+		t.Errorf("Should list the consumable first, not %s %d", purchases[0].ProductID(), purchases[0].Quantity())
+	}
+	if purchases[1].ProductID() != "month-premium" {
+		t.Errorf("Should list the subscription last, not %s", purchases[1].ProductID())
+	}
+}
+
+func TestOneTimePurchases(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response10.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	for _, purchase := range resp.InAppPurchases() {
+		if purchase.ProductType() != ProductTypeOneTime || !purchase.ExpiresAt().IsZero() {
+			t.Errorf("Should not expire consumable %s", purchase.TransactionID())
+		}
+	}
+
+	transaction, ok := resp.LatestActiveTransaction("coins-100")
+	if !ok || transaction.TransactionID() != "623456789012346" {
+		t.Errorf("Should find the latest consumable purchase, not %v", transaction)
+	}
+	if _, ok := resp.LatestActiveTransaction("coins-500"); ok {
+		t.Error("Should not find a refunded consumable")
+	}
+
+	entitlements := resp.EntitlementsByProduct(time.Now())
+	if len(entitlements) != 1 || entitlements["coins-100"] == nil {
+		t.Errorf("Should only entitle the unrefunded consumable, not %v", entitlements)
+	}
+}
+
+func TestProductType(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response8.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	for _, transaction := range resp.AllTransactions() {
+		productType := ProductTypeAutoRenewable
+		if transaction.ProductID() == "lifetime-stickers" {
+			productType = ProductTypeOneTime
+		}
+		if transaction.ProductType() != productType {
+			t.Errorf("Should infer %s for %s, not %s", productType, transaction.ProductID(), transaction.ProductType())
+		}
+	}
+
+	signed := SignedTransaction{JWSTransactionBody{Type: "Consumable"}}
+	if signed.ProductType() != ProductTypeConsumable {
+		t.Errorf("Should read signed transaction type, not %s", signed.ProductType())
+	}
+}
+
+func TestParseCancellationReason(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response8.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	for _, transaction := range resp.AllTransactions() {
+		reason, ok := transaction.CancellationReason()
+		if transaction.CancelledAt().IsZero() && ok {
+			t.Errorf("Should not have a cancellation reason for %s", transaction.TransactionID())
+		}
+		if !transaction.CancelledAt().IsZero() && (!ok || reason != CancellationReasonAppIssue) {
+			t.Errorf("Should parse app issue cancellation reason for %s, not %d", transaction.TransactionID(), reason)
+		}
+	}
+
+	reason := 0
+	ios6 := IOS6ReceiptInfo{ReceiptInfoBody{CancellationReason: &reason}}
+	if r, ok := ios6.CancellationReason(); !ok || r != CancellationReasonOther {
+		t.Errorf("Should read iOS 6 style cancellation reason, not %d", r)
+	}
+}
+
+func TestVerifyResultJSONRoundTrip(t *testing.T) {
+	for _, fileName := range []string{"response1.json", "response3.json", "response5.json", "response8.json",
+		"response9.json"} {
+		data, readErr := ioutil.ReadFile("testdata/" + fileName)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+
+		resp, parseErr := parseReceiptResponse(data)
+		if parseErr != nil {
+			t.Fatal(parseErr)
+		}
+
+		cached, marshalErr := json.Marshal(resp)
+		if marshalErr != nil {
+			t.Fatal(marshalErr)
+		}
+
+		var restored VerifyResult
+		if err := json.Unmarshal(cached, &restored); err != nil {
+			t.Fatal(err)
+		}
+
+		if fmt.Sprint(newCachedTransaction(restored)) != fmt.Sprint(newCachedTransaction(resp)) {
+			t.Errorf("%s: Should restore latest transaction %v, not %v", fileName, newCachedTransaction(resp),
+				newCachedTransaction(restored))
+		}
+		if restored.Status() != resp.Status() || restored.AutoRenewStatus() != resp.AutoRenewStatus() ||
+			!restored.CancelledAt().Equal(resp.CancelledAt()) {
+			t.Errorf("%s: Should restore status", fileName)
+		}
+		if restored.Environment != resp.Environment || restored.LatestReceipt != resp.LatestReceipt ||
+			fmt.Sprint(restored.Receipt) != fmt.Sprint(resp.Receipt) ||
+			len(restored.PendingRenewalInfo) != len(resp.PendingRenewalInfo) {
+			t.Errorf("%s: Should restore receipt", fileName)
+		}
+
+		transactions, restoredTransactions := resp.AllTransactions(), restored.AllTransactions()
+		if len(restoredTransactions) != len(transactions) {
+			t.Fatalf("%s: Should restore %d transactions, not %d", fileName, len(transactions), len(restoredTransactions))
+		}
+		for i := range transactions {
+			if newCachedTransaction(restoredTransactions[i]) != newCachedTransaction(transactions[i]) {
+				t.Errorf("%s: Should restore transaction %d", fileName, i)
+			}
+		}
+
+		// Encoding again should be stable
+		if again, _ := json.Marshal(restored); string(again) != string(cached) {
+			t.Errorf("%s: Should encode the same JSON after a round trip:\n%s\n%s", fileName, cached, again)
+		}
+	}
+}
+
+func TestParseWebOrderLineItemID(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response5.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if resp.WebOrderLineItemID() != "120000123456789" {
+		t.Errorf("Should parse web order line item ID, not %q", resp.WebOrderLineItemID())
+	}
+
+	data, readErr = ioutil.ReadFile("testdata/response3.json")
+	if readErr != nil {
+		t.Error(readErr)
+	}
+
+	resp, parseErr = parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if resp.WebOrderLineItemID() != "" {
+		t.Errorf("Should leave missing web order line item ID empty, not %q", resp.WebOrderLineItemID())
+	}
+}
+
+func TestParsePriceConsentStatus(t *testing.T) {
+	cases := []struct {
+		json      string
+		pending   bool
+		consented bool
+	}{
+		{`{"original_transaction_id":"123456789012345"}`, false, false},
+		{`{"original_transaction_id":"123456789012345","price_consent_status":"0"}`, true, false},
+		{`{"original_transaction_id":"123456789012345","price_consent_status":"1"}`, false, true},
+		{`{"original_transaction_id":"123456789012345","price_increase_status":"0"}`, true, false},
+		{`{"original_transaction_id":"123456789012345","price_increase_status":"1"}`, false, true},
+	}
+
+	for _, c := range cases {
+		var info PendingRenewalInfo
+		if err := json.Unmarshal([]byte(c.json), &info); err != nil {
+			t.Fatal(err)
+		}
+
+		result := MockResult(ReceiptInfoBody{OriginalTransactionID: "123456789012345"}, info)
+		if result.PriceIncreasePending() != c.pending || result.PriceConsented() != c.consented {
+			t.Errorf("%s should be pending %t and consented %t", c.json, c.pending, c.consented)
+		}
+	}
+}
+
+func TestParseMalformedResponses(t *testing.T) {
+	for _, data := range []string{
+		``,
+		`null`,
+		`[]`,
+		`{"status":0}`,
+		`{"status":0,"receipt":"receipt123"}`,
+		`{"status":0,"receipt":null}`,
+		`{"status":0,"latest_receipt_info":"receipt123"}`,
+		`{"status":0,"latest_receipt_info":[]}`,
+		`{"status":0,"latest_receipt_info":[1]}`,
+		`{"status":0,"latest_receipt_info":[{"product_id":`,
+		`{"status":0,"receipt":{"bundle_id":1},"latest_receipt_info":[{}]}`,
+		`{"status":0,"pending_renewal_info":{},"latest_receipt_info":[{}]}`,
+	} {
+		if _, err := parseReceiptResponse([]byte(data)); err == nil {
+			t.Errorf("Should fail to parse %s", data)
+		}
+	}
+}
+
+func FuzzParseReceiptResponse(f *testing.F) {
+	for _, fileName := range []string{"response1.json", "response2.json", "response3.json", "response4.json",
+		"response5.json", "response6.json", "response7.json", "response8.json", "response9.json",
+		"response10.json", "response11.json", "response12.json", "response13.json", "response14.json",
+		"response15.json", "response16.json", "response17.json"} {
+		data, readErr := ioutil.ReadFile("testdata/" + fileName)
+		if readErr != nil {
+			f.Fatal(readErr)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte(`{"status":0,"latest_receipt_info":[]}`))
+	f.Add([]byte(`{"status":0,"receipt":null}`))
+	f.Add([]byte(`{"status":0,"receipt":[{"in_app":{}}]}`))
+
+	// Logging every malformed response would flood the fuzzer's output
+	log.SetOutput(ioutil.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		result, err := parseReceiptResponse(data)
+		if err != nil {
+			return
+		}
+
+		// Every accessor of a parsed result should be safe to call
+		_ = result.Status()
+		_ = result.ExpiresAt()
+		_ = result.InAppPurchases()
+		_ = result.EntitlementsByProduct(time.Now())
+		_ = result.State(time.Now())
+		if _, err := json.Marshal(result); err != nil {
+			t.Errorf("Should encode a parsed result: %s", err)
+		}
+	})
+}
+
+func TestDiff(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+	stored := MockResult(ReceiptInfoBody{
+		ProductID:     "month-premium",
+		TransactionID: "1000000000000001",
+		ExpiresDate:   newMillistamp(now),
+		IsTrialPeriod: true,
+	}).Info
+
+	// Decoding the same transaction another way changes nothing that matters
+	if !Equal(stored, newCachedTransaction(stored).transaction()) {
+		t.Errorf("Should equal its cached copy, not %v", Diff(stored, newCachedTransaction(stored).transaction()))
+	}
+
+	reason := int(CancellationReasonAppIssue)
+	cancellationDate := newMillistamp(now.AddDate(0, 0, -1))
+	renewed := MockResult(ReceiptInfoBody{
+		ProductID:          "month-premium",
+		TransactionID:      "1000000000000002",
+		ExpiresDate:        newMillistamp(now.AddDate(0, 1, 0)),
+		CancellationDate:   &cancellationDate,
+		CancellationReason: &reason,
+	}).Info
+
+	if Equal(stored, renewed) {
+		t.Error("Should tell a renewed transaction apart")
+	}
+
+	changes := Diff(stored, renewed)
+	var fields []string
+	for _, change := range changes {
+		fields = append(fields, change.Field)
+	}
+	if expected := "[ExpiresAt IsTrialPeriod CancelledAt CancellationReason]"; fmt.Sprint(fields) != expected {
+		t.Fatalf("Should report %v changed, not %v", expected, fields)
+	}
+	if changes[0].Old != now || changes[0].New != now.AddDate(0, 1, 0) {
+		t.Errorf("Should report the expiry change, not %v", changes[0])
+	}
+	if changes[3].Old != nil || changes[3].New != CancellationReasonAppIssue {
+		t.Errorf("Should report the refund, not %v", changes[3])
+	}
+
+	if changes := Diff(nil, renewed); len(changes) != len(diffFields) || changes[0].Old != nil {
+		t.Errorf("Should report every field of a new transaction, not %v", changes)
+	}
+	if !Equal(nil, nil) {
+		t.Error("Should equal nothing with nothing")
+	}
+	if changes := Diff(VerifyResult{}, renewed); fmt.Sprint(changes) != fmt.Sprint(Diff(nil, renewed)) {
+		t.Errorf("Should treat a result without Info as nothing, not %v", changes)
+	}
+}
+
+func TestParseNumericDates(t *testing.T) {
+	expiresAt := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, data := range []string{
+		`{"status":0,"latest_receipt_info":[{"product_id":"month-premium","expires_date_ms":"1622505600000"}]}`,
+		`{"status":0,"latest_receipt_info":[{"product_id":"month-premium","expires_date_ms":1622505600000}]}`,
+	} {
+		for _, strict := range []bool{false, true} {
+			resp, err := decodeReceiptResponse([]byte(data), strict)
+			if err != nil {
+				t.Errorf("Should decode %s, not %v", data, err)
+				continue
+			}
+			if !resp.ExpiresAt().Equal(expiresAt) {
+				t.Errorf("Should expire at %s for %s, not %s", expiresAt, data, resp.ExpiresAt())
+			}
+		}
+	}
+}
+
+func TestIsInitialPurchase(t *testing.T) {
+	initial := MockResult(ReceiptInfoBody{TransactionID: "1000000000000001", OriginalTransactionID: "1000000000000001"})
+	if !initial.IsInitialPurchase() {
+		t.Error("Should be the initial purchase when the transaction is the original")
+	}
+
+	renewal := MockResult(ReceiptInfoBody{TransactionID: "1000000000000002", OriginalTransactionID: "1000000000000001"})
+	if renewal.IsInitialPurchase() {
+		t.Error("Should be a renewal when the transaction isn't the original")
+	}
+
+	if MockResult(ReceiptInfoBody{}).IsInitialPurchase() {
+		t.Error("Should not be the initial purchase without a transaction ID")
+	}
+
+	ios6 := IOS6ReceiptInfo{ReceiptInfoBody{TransactionID: "1000000000000001", OriginalTransactionID: "1000000000000001"}}
+	if !ios6.IsInitialPurchase() {
+		t.Error("Should tell iOS 6 style initial purchases apart too")
+	}
+}
+
+func TestParseReceiptCreationDate(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/response15.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	resp, parseErr := parseReceiptResponse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	createdAt := time.Date(2021, time.May, 15, 0, 0, 0, 0, time.UTC)
+	if !resp.ReceiptCreationDate().Equal(createdAt) {
+		t.Errorf("Should parse %s as %s", resp.ReceiptCreationDate(), createdAt)
+	}
+
+	cached, marshalErr := json.Marshal(resp)
+	if marshalErr != nil {
+		t.Fatal(marshalErr)
+	}
+	var restored VerifyResult
+	if err := json.Unmarshal(cached, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.ReceiptCreationDate().Equal(createdAt) {
+		t.Errorf("Should keep the creation date when cached, not %s", restored.ReceiptCreationDate())
+	}
+
+	if !MockResult(ReceiptInfoBody{}).ReceiptCreationDate().IsZero() {
+		t.Error("Should have no creation date without a receipt")
+	}
 }
@@ -0,0 +1,35 @@
+package receipt
+
+// Verifier verifies base64 encoded receipt data. Client verifies with the App Store, while
+// MockVerifier lets apps test their subscription logic without it.
+type Verifier interface {
+	Verify(receipt string) (VerifyResult, error)
+}
+
+// MockVerifier returns canned results by receipt data, or Err for any other receipt
+type MockVerifier struct {
+	Results map[string]VerifyResult
+	Err     error
+}
+
+func (m MockVerifier) Verify(receipt string) (VerifyResult, error) {
+	result, ok := m.Results[receipt]
+	if !ok {
+		if m.Err != nil {
+			return VerifyResult{}, m.Err
+		}
+		return VerifyResult{}, StatusError{StatusUnreadable}
+	}
+	return result, nil
+}
+
+// MockResult builds a valid VerifyResult whose latest transaction is body, such as to return
+// from a MockVerifier. The auto-renew status comes from the first pending renewal info.
+func MockResult(body ReceiptInfoBody, renewals ...PendingRenewalInfo) VerifyResult {
+	var v validation
+	v.response.Status = StatusValid
+	v.response.info = modernReceiptInfo{body}
+	v.response.transactions = []Transaction{v.response.info}
+	v.response.renewalInfo = renewals
+	return v.result()
+}
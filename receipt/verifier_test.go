@@ -0,0 +1,36 @@
+package receipt
+
+import (
+	"testing"
+	"time"
+)
+
+var _ Verifier = New("password")
+
+func TestMockVerifier(t *testing.T) {
+	expiresAt := time.Date(2019, time.May, 1, 0, 0, 0, 0, time.UTC)
+
+	var verifier Verifier = MockVerifier{
+		Results: map[string]VerifyResult{
+			"receipt123": MockResult(ReceiptInfoBody{
+				ProductID:   "month-premium",
+				ExpiresDate: Millistamp(expiresAt.UnixNano() / int64(time.Millisecond)),
+			}, PendingRenewalInfo{AutoRenewStatus: 1}),
+		},
+	}
+
+	result, err := verifier.Verify("receipt123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ProductID() != "month-premium" || !result.ExpiresAt().Equal(expiresAt) {
+		t.Errorf("Should return canned result, not %s %s", result.ProductID(), result.ExpiresAt())
+	}
+	if result.Status() != StatusValid || !result.AutoRenewStatus() {
+		t.Error("Should be valid and auto-renewing")
+	}
+
+	if _, err := verifier.Verify("receipt456"); err == nil {
+		t.Error("Should fail to verify unknown receipt")
+	}
+}